@@ -0,0 +1,118 @@
+package spsa
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//********** Checkpoints ***********
+
+// The resumable state of an SPSA run. Gain sequences and perturbation
+// distributions can't be serialized, so a resumed run recreates them and
+// Resume fast-forwards the gain sequences past the rounds already run. The
+// current values of the adaptive gains AdaptCk and AdaptAk and the AdaGrad
+// accumulators are saved too.
+//
+// The rest of the run's history is not saved and starts over on resume: the
+// windows of AdaptAk and PlateauKick, the last gradient of AdaptCk and
+// LastGradientCosine, the Temperature loss cache, LastLoss and LossHistory.
+type Checkpoint struct {
+	Theta       Vector
+	Round       int
+	Evaluations int
+
+	AdaptCk     float64 `json:",omitempty"` // AdaptCk.C, if set
+	AdaptAk     float64 `json:",omitempty"` // AdaptAk.A, if set
+	GradSqAccum Vector  `json:",omitempty"` // The AdaGrad accumulators
+}
+
+// The current resumable state.
+func (spsa *SPSA) Checkpoint() Checkpoint {
+	cp := Checkpoint{Theta: spsa.Theta.Copy(), Round: spsa.k, Evaluations: spsa.evals}
+	if spsa.AdaptCk != nil {
+		cp.AdaptCk = spsa.AdaptCk.C
+	}
+	if spsa.AdaptAk != nil {
+		cp.AdaptAk = spsa.AdaptAk.A
+	}
+	if spsa.gradSqAccum != nil {
+		cp.GradSqAccum = spsa.gradSqAccum.Copy()
+	}
+	return cp
+}
+
+// Write the current Checkpoint to path as JSON. The file is replaced
+// atomically, so a crash while writing leaves the previous checkpoint intact.
+func (spsa *SPSA) WriteCheckpoint(path string) error {
+	data, err := json.Marshal(spsa.Checkpoint())
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// Read a Checkpoint written by WriteCheckpoint.
+func LoadCheckpoint(path string) (Checkpoint, error) {
+	var cp Checkpoint
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cp, err
+	}
+	err = json.Unmarshal(data, &cp)
+	return cp, err
+}
+
+// Resume from a checkpoint: restore theta, the counters and the adaptive gains
+// that are set on spsa, and advance the freshly created gain sequences Ak (or
+// AkVector), Ck, PerturbScale and Temperature past the cp.Round rounds already run.
+func (spsa *SPSA) Resume(cp Checkpoint) {
+	spsa.Theta = cp.Theta.Copy()
+	spsa.k, spsa.evals = cp.Round, cp.Evaluations
+	spsa.hasPeekedCk, spsa.hasPeekedPk = false, false
+	if spsa.AdaptCk != nil && cp.AdaptCk != 0 {
+		spsa.AdaptCk.C = cp.AdaptCk
+	}
+	if spsa.AdaptAk != nil && cp.AdaptAk != 0 {
+		spsa.AdaptAk.A = cp.AdaptAk
+	}
+	if cp.GradSqAccum != nil {
+		spsa.gradSqAccum = cp.GradSqAccum.Copy()
+	}
+
+	for i := 0; i < cp.Round; i++ {
+		if spsa.AkVector != nil {
+			<-spsa.AkVector
+		} else if spsa.Ak != nil {
+			<-spsa.Ak
+		}
+		if spsa.AdaptCk == nil && spsa.Ck != nil {
+			<-spsa.Ck
+		}
+		if spsa.PerturbScale != nil {
+			<-spsa.PerturbScale
+		}
+		if spsa.Temperature != nil {
+			<-spsa.Temperature
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package spsa
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSPSACheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spsa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint.json")
+
+	// The deltas cycle every round, so a fresh distribution resumes in step
+	newSPSA := func() *SPSA {
+		return &SPSA{
+			L:     AbsoluteSum,
+			C:     NoConstraints,
+			Theta: Vector{1, 1, 1, 1, 1},
+			Ak:    StandardAk(1, 10, .602),
+			Ck:    StandardCk(.1, .101),
+			Delta: NewDeterministicBernoulli(1, 1, -1, -1, 1, 1),
+		}
+	}
+	want := newSPSA().Run(50)
+
+	// Crash after 35 rounds, with the last checkpoint at round 30
+	crashed := newSPSA()
+	crashed.CheckpointEvery, crashed.CheckpointPath = 10, path
+	if _, err := crashed.RunChecked(35); err != nil {
+		t.Fatal("Checkpointing failed.", err)
+	}
+
+	cp, err := LoadCheckpoint(path)
+	if err != nil {
+		t.Fatal("Loading the checkpoint failed.", err)
+	} else if cp.Round != 30 || cp.Evaluations != 60 {
+		t.Error("The checkpoint wasn't the last one written.", cp.Round, cp.Evaluations)
+	}
+
+	resumed := newSPSA()
+	resumed.Resume(cp)
+	if got := resumed.Run(20); !reflect.DeepEqual(got, want) {
+		t.Error("The resumed run didn't continue where the checkpoint left off.", got.String(), want.String())
+	} else if resumed.Evaluations() != 100 {
+		t.Error("The resumed run didn't restore the evaluation count.", resumed.Evaluations())
+	}
+}
+
+func TestSPSAResumeTemperature(t *testing.T) {
+	spsa := &SPSA{
+		L:           AbsoluteSum,
+		C:           NoConstraints,
+		Theta:       Vector{1, 1},
+		Ak:          StandardAk(.1, 10, .602),
+		Ck:          StandardCk(.1, .101),
+		Delta:       Bernoulli{1},
+		Temperature: SliceGain([]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}),
+	}
+	spsa.Run(5)
+
+	resumed := &SPSA{Temperature: SliceGain([]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11})}
+	resumed.Resume(spsa.Checkpoint())
+	if T := <-resumed.Temperature; T != 6 {
+		t.Error("Resume didn't advance the Temperature schedule.", T)
+	}
+}
+
+func TestSPSAResumeAdaptiveGains(t *testing.T) {
+	spsa := &SPSA{
+		L:       AbsoluteSum,
+		C:       NoConstraints,
+		Theta:   Vector{1, 1, 1},
+		AdaptAk: &AdaptiveAk{A: .01, Target: .6, Factor: 1.2, Window: 5},
+		AdaptCk: &AdaptiveCk{C: .1, Min: .01, Max: 1, Factor: 1.1, Tolerance: .5},
+		AdaGrad: true,
+		Delta:   Bernoulli{1},
+	}
+	spsa.Run(20)
+
+	resumed := &SPSA{
+		AdaptAk: &AdaptiveAk{A: .01, Target: .6, Factor: 1.2, Window: 5},
+		AdaptCk: &AdaptiveCk{C: .1, Min: .01, Max: 1, Factor: 1.1, Tolerance: .5},
+	}
+	resumed.Resume(spsa.Checkpoint())
+
+	if resumed.AdaptAk.A != spsa.AdaptAk.A || resumed.AdaptCk.C != spsa.AdaptCk.C {
+		t.Error("Resume didn't restore the adaptive gains.", resumed.AdaptAk.A, resumed.AdaptCk.C)
+	} else if !reflect.DeepEqual(resumed.gradSqAccum, spsa.gradSqAccum) {
+		t.Error("Resume didn't restore the AdaGrad accumulators.", resumed.gradSqAccum, spsa.gradSqAccum)
+	}
+}
+
+func TestWriteCheckpointRenameFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spsa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Renaming a file over a non-empty directory fails
+	path := filepath.Join(dir, "checkpoint")
+	if err := os.MkdirAll(filepath.Join(path, "x"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	spsa := &SPSA{Theta: Vector{1}}
+	if err := spsa.WriteCheckpoint(path); err == nil {
+		t.Error("WriteCheckpoint didn't fail to replace a directory.")
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Error("WriteCheckpoint left its temporary file behind.", len(files))
+	}
+}
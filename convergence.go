@@ -0,0 +1,264 @@
+package spsa
+
+import (
+	"math"
+	"math/rand"
+)
+
+//********** Convergence Detection ***********
+
+// A stopping criterion for SPSA.RunUntil. Done is called after every round with
+// the 1-based round number, the current theta and the loss at theta, and
+// reports whether to stop. Implementations may be stateful.
+type Convergence interface {
+	Done(round int, theta Vector, loss float64) bool
+}
+
+// Run rounds of SPSA until c reports convergence and return the current Theta value.
+// This evaluates the loss at theta once per round on top of the two gradient
+// evaluations, or reuses LastLoss if TrackLoss is set. Like Run, it stops early
+// if theta diverges or a checkpoint write fails. The number of rounds isn't known
+// in advance, so PolyakFraction doesn't apply.
+func (spsa *SPSA) RunUntil(c Convergence) Vector {
+	spsa.begin(0)
+	for round := 1; ; round++ {
+		if err := spsa.step(false); err != nil {
+			return spsa.Theta
+		}
+		if c.Done(round, spsa.Theta, spsa.currentLoss()) {
+			return spsa.Theta
+		}
+	}
+}
+
+// The loss at the current theta, tracked by the last round if TrackLoss is set.
+// It is evaluated if theta changed since, e.g. before the first round.
+func (spsa *SPSA) currentLoss() float64 {
+	if spsa.tracksLoss() && spsa.Theta.equal(spsa.lossTheta) {
+		return spsa.LastLoss
+	}
+	return spsa.evaluate(spsa.Theta)
+}
+
+// Set LastLoss to the loss at the current theta.
+func (spsa *SPSA) setLastLoss(loss float64) {
+	spsa.LastLoss, spsa.lossTheta = loss, spsa.Theta.Copy()
+}
+
+// An adapter to use an ordinary function as a Convergence criterion.
+type ConvergenceFunc func(round int, theta Vector, loss float64) bool
+
+func (f ConvergenceFunc) Done(round int, theta Vector, loss float64) bool {
+	return f(round, theta, loss)
+}
+
+// Stop after a fixed number of rounds.
+type MaxRounds int
+
+func (mr MaxRounds) Done(round int, theta Vector, loss float64) bool {
+	return round >= int(mr)
+}
+
+// Stop when the loss has plateaued over a window of the last Window rounds. The
+// improvement is the mean loss of the older half of the window minus the mean of
+// the newer half, optionally Relative to the older mean's magnitude (absolute if
+// that is 0), and the run stops when it drops below Tol. Noisy losses need larger
+// windows than smooth ones. Recent losses are kept in a ring buffer of Window values.
+type LossPlateau struct {
+	Tol      float64
+	Window   int
+	Relative bool
+
+	buf  []float64
+	next int
+	full bool
+}
+
+func (lp *LossPlateau) Done(round int, theta Vector, loss float64) bool {
+	if lp.Window < 2 {
+		lp.Window = 2
+	}
+	if len(lp.buf) != lp.Window {
+		lp.buf, lp.next, lp.full = make([]float64, lp.Window), 0, false
+	}
+
+	lp.buf[lp.next] = loss
+	lp.next = (lp.next + 1) % lp.Window
+	if lp.next == 0 {
+		lp.full = true
+	}
+	if !lp.full {
+		return false
+	}
+
+	// The oldest loss is at lp.next
+	half := lp.Window / 2
+	var older, newer float64
+	for i := 0; i < half; i++ {
+		older += lp.buf[(lp.next+i)%lp.Window]
+		newer += lp.buf[(lp.next+lp.Window-half+i)%lp.Window]
+	}
+	older, newer = older/float64(half), newer/float64(half)
+
+	improvement := older - newer
+	if lp.Relative && older != 0 {
+		improvement /= math.Abs(older)
+	}
+	return improvement < lp.Tol
+}
+
+// A plateau escape heuristic for SPSA.Kick. When the loss at theta hasn't
+// improved on its best value for Patience rounds, theta is moved by a random
+// kick of norm Size (frozen coordinates excepted) and the run continues. This
+// is a simple restart in place for runs stalled on a flat or noisy plateau.
+// Since a converged run also stops improving, MaxKicks, if positive, limits
+// the number of kicks.
+type PlateauKick struct {
+	Patience int
+	Size     float64
+	MaxKicks int
+
+	// Number of kicks so far
+	Kicks int
+
+	best  float64
+	since int
+	seen  bool
+}
+
+// Whether the run has stalled, given the loss at theta this round.
+func (pk *PlateauKick) stalled(loss float64) bool {
+	if !pk.seen || loss < pk.best {
+		pk.best, pk.since, pk.seen = loss, 0, true
+		return false
+	}
+	pk.since++
+	if pk.since < pk.Patience || (pk.MaxKicks > 0 && pk.Kicks >= pk.MaxKicks) {
+		return false
+	}
+	pk.since = 0
+	return true
+}
+
+// Kick theta by Size in a uniformly random direction of the unfrozen coordinates. (out of place)
+func (pk *PlateauKick) kick(theta Vector, frozen func(int) bool) Vector {
+	pk.Kicks++
+	return randomKick(theta, pk.Size, frozen)
+}
+
+// Move theta by size in a uniformly random direction of the unfrozen coordinates. (out of place)
+func randomKick(theta Vector, size float64, frozen func(int) bool) Vector {
+	dir := make(Vector, len(theta))
+	for i := range dir {
+		if !frozen(i) {
+			dir[i] = rand.NormFloat64()
+		}
+	}
+	return theta.AddScaled(dir.Normalize(), size)
+}
+
+// A restart policy for calling Run repeatedly. It compares the loss at theta to
+// the loss at the previous call, and if it improved by less than threshold,
+// kicks theta by RestartSize in a random direction (like PlateauKick) and reports
+// true. The first call only records the loss. The next call compares against
+// the loss at the kicked theta. This costs one loss evaluation, or none if
+// TrackLoss is set, plus one after a kick.
+func (spsa *SPSA) MaybeRestart(threshold float64) bool {
+	loss := spsa.currentLoss()
+	stalled := spsa.restartSeen && spsa.restartLoss-loss < threshold
+	spsa.restartLoss, spsa.restartSeen = loss, true
+
+	if stalled {
+		spsa.Theta = spsa.C(randomKick(spsa.Theta, spsa.RestartSize, spsa.frozen))
+		spsa.restartLoss = spsa.evaluate(spsa.Theta)
+		if spsa.tracksLoss() {
+			spsa.setLastLoss(spsa.restartLoss)
+		}
+	}
+	return stalled
+}
+
+// Stop when theta moves less than Tol (in Euclidean norm) in one round.
+type StepTolerance struct {
+	Tol float64
+
+	last Vector
+}
+
+func (st *StepTolerance) Done(round int, theta Vector, loss float64) bool {
+	last := st.last
+	st.last = theta.Copy()
+	return last != nil && theta.Subtract(last).Norm() < st.Tol
+}
+
+// Stop when the gradient estimate at theta is dominated by noise, i.e. when
+// SPSA.GradientSNR with Samples estimates falls below Threshold. It is checked
+// every Every rounds (every round if not positive), each check costing
+// 2 * Samples loss evaluations. This is more robust than a loss plateau for
+// noisy losses, where the loss itself fluctuates as much as it improves.
+type LowSNR struct {
+	SPSA      *SPSA
+	Every     int
+	Samples   int
+	Threshold float64
+}
+
+func (ls LowSNR) Done(round int, theta Vector, loss float64) bool {
+	if ls.Every > 1 && round%ls.Every != 0 {
+		return false
+	}
+	return ls.SPSA.GradientSNR(ls.Samples) < ls.Threshold
+}
+
+// Stop when any of the criteria is done. Every criterion is checked each round
+// so that stateful criteria stay up to date.
+type Any []Convergence
+
+func (a Any) Done(round int, theta Vector, loss float64) bool {
+	done := false
+	for _, c := range a {
+		if c.Done(round, theta, loss) {
+			done = true
+		}
+	}
+	return done
+}
+
+// Stop when all of the criteria are done. Every criterion is checked each round
+// so that stateful criteria stay up to date.
+type All []Convergence
+
+func (a All) Done(round int, theta Vector, loss float64) bool {
+	done := len(a) > 0
+	for _, c := range a {
+		if !c.Done(round, theta, loss) {
+			done = false
+		}
+	}
+	return done
+}
+
+// Estimate the empirical convergence rate of a loss history such as
+// SPSA.LossHistory, the least squares slope of log(loss) against log(k) for the
+// 1-based round k. A loss decaying like k^-r has a slope of -r. Non-positive
+// losses are skipped, and NaN is returned with fewer than two usable points.
+func EstimateConvergenceRate(lossHistory []float64) float64 {
+	var x, y Vector
+	for i, loss := range lossHistory {
+		if loss > 0 {
+			x = append(x, math.Log(float64(i+1)))
+			y = append(y, math.Log(loss))
+		}
+	}
+	if len(x) < 2 {
+		return math.NaN()
+	}
+
+	mx, my := x.Mean(), y.Mean()
+	var sxy, sxx float64
+	for i := range x {
+		sxy += (x[i] - mx) * (y[i] - my)
+		sxx += (x[i] - mx) * (x[i] - mx)
+	}
+	return sxy / sxx
+}
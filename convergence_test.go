@@ -0,0 +1,322 @@
+package spsa
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestMaxRounds(t *testing.T) {
+	c := MaxRounds(3)
+	if c.Done(2, Vector{1}, 1) || !c.Done(3, Vector{1}, 1) {
+		t.Error("MaxRounds didn't stop at the right round.")
+	}
+}
+
+func TestLossPlateau(t *testing.T) {
+	c := &LossPlateau{Tol: .1, Window: 4}
+	losses := []float64{5, 4, 3, 2.5, 2.4, 2.35, 2.33, 2.32, 2.315}
+	done := []bool{false, false, false, false, false, false, false, true, true}
+
+	for i, l := range losses {
+		if c.Done(i+1, Vector{1}, l) != done[i] {
+			t.Error("LossPlateau didn't detect the plateau correctly.", i, l)
+		}
+	}
+
+	r := &LossPlateau{Tol: .01, Window: 2, Relative: true}
+	r.Done(1, nil, 1000)
+	if r.Done(2, nil, 995) != true || r.Done(3, nil, 900) != false {
+		t.Error("Relative LossPlateau didn't measure relative improvement.")
+	}
+
+	zero := &LossPlateau{Tol: .01, Window: 2, Relative: true}
+	zero.Done(1, nil, 0)
+	if !zero.Done(2, nil, 0) {
+		t.Error("Relative LossPlateau didn't stop on a loss of 0.")
+	}
+}
+
+func TestLossPlateauSmooth(t *testing.T) {
+	c := &LossPlateau{Tol: 1e-3, Window: 4}
+	for k := 1; k <= 1000; k++ {
+		if c.Done(k, nil, math.Exp(-float64(k)/10)) {
+			if k < 50 {
+				t.Error("LossPlateau stopped a smooth loss too early.", k)
+			}
+			return
+		}
+	}
+	t.Error("LossPlateau with a small window never stopped a smooth loss.")
+}
+
+func TestLossPlateauNoisy(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	// A steadily decreasing loss with noise much larger than the per-round decrease
+	noisy := func(k int) float64 {
+		return 100 - .1*float64(k) + rng.NormFloat64()
+	}
+
+	small, large := &LossPlateau{Tol: .5, Window: 4}, &LossPlateau{Tol: .5, Window: 200}
+	smallDone, largeDone := false, false
+	for k := 1; k <= 800; k++ {
+		l := noisy(k)
+		smallDone = small.Done(k, nil, l) || smallDone
+		largeDone = large.Done(k, nil, l) || largeDone
+	}
+
+	if !smallDone {
+		t.Error("LossPlateau with a small window was expected to falsely stop the noisy loss.")
+	}
+	if largeDone {
+		t.Error("LossPlateau with a large window falsely stopped the noisy loss.")
+	}
+}
+
+func TestStepTolerance(t *testing.T) {
+	c := &StepTolerance{Tol: .1}
+	thetas := []Vector{{0, 0}, {1, 0}, {1, .5}, {1, .55}}
+	done := []bool{false, false, false, true}
+
+	for i, theta := range thetas {
+		if c.Done(i+1, theta, 0) != done[i] {
+			t.Error("StepTolerance didn't detect the small step correctly.", i, theta.String())
+		}
+	}
+}
+
+func TestAnyAll(t *testing.T) {
+	first := Any{MaxRounds(2), MaxRounds(4)}
+	last := All{MaxRounds(2), MaxRounds(4)}
+
+	if first.Done(1, nil, 0) || !first.Done(2, nil, 0) {
+		t.Error("Any didn't stop when the first criterion was done.")
+	}
+	if last.Done(2, nil, 0) || !last.Done(4, nil, 0) {
+		t.Error("All didn't stop when the last criterion was done.")
+	}
+	if (All{}).Done(1, nil, 0) {
+		t.Error("An empty All stopped.")
+	}
+}
+
+func TestRunUntil(t *testing.T) {
+	spsa := &SPSA{
+		L:     func(v Vector) float64 { return v.MeanSquare() },
+		C:     NoConstraints,
+		Theta: Vector{1, 1, 1, 1, 1},
+		Ak:    StandardAk(1, 100, .602),
+		Ck:    StandardCk(.1, .101),
+		Delta: Bernoulli{1},
+	}
+
+	rounds := 0
+	counter := ConvergenceFunc(func(round int, theta Vector, loss float64) bool {
+		rounds = round
+		return false
+	})
+	theta := spsa.RunUntil(Any{counter, &LossPlateau{Tol: 1e-9, Window: 50}, MaxRounds(5000)})
+
+	if rounds >= 5000 {
+		t.Error("RunUntil didn't stop on the loss plateau.")
+	} else if theta.MeanSquare() > .001 {
+		t.Error("SPSA/RunUntil didn't optimize the quadratic function very well...", theta.String())
+	}
+}
+
+func TestRunUntilDivergence(t *testing.T) {
+	spsa := &SPSA{
+		L:               func(v Vector) float64 { return -v.Dot(v) },
+		C:               NoConstraints,
+		Theta:           Vector{1, 1},
+		Ak:              StandardAk(1, 10, .602),
+		Ck:              StandardCk(.1, .101),
+		Delta:           Bernoulli{1},
+		DivergenceLimit: 100,
+	}
+	theta := spsa.RunUntil(MaxRounds(200))
+
+	if theta.Norm() > 100 {
+		t.Error("RunUntil didn't restore the last theta within the limit.", theta.String())
+	} else if spsa.k >= 200 {
+		t.Error("RunUntil didn't stop when theta diverged.", spsa.k)
+	}
+}
+
+func TestSPSAKick(t *testing.T) {
+	// Flat away from a ring-shaped basin of radius 3
+	L := func(v Vector) float64 {
+		return math.Min(1, math.Pow(v.Norm()-3, 2))
+	}
+	newSPSA := func() *SPSA {
+		return &SPSA{
+			L:     L,
+			C:     NoConstraints,
+			Theta: Vector{0, 0},
+			Ak:    StandardAk(.1, 10, .602),
+			Ck:    StandardCk(.1, .101),
+			Delta: Bernoulli{1},
+		}
+	}
+
+	stuck := newSPSA().Run(100)
+	if L(stuck) != 1 {
+		t.Error("SPSA left the flat region without a kick.", stuck.String())
+	}
+
+	spsa := newSPSA()
+	spsa.Kick = &PlateauKick{Patience: 5, Size: 3, MaxKicks: 1}
+	theta := spsa.Run(100)
+	if spsa.Kick.Kicks != 1 {
+		t.Error("SPSA didn't kick theta off the plateau exactly once.", spsa.Kick.Kicks)
+	} else if L(theta) > .01 {
+		t.Error("The kick didn't help SPSA reach the basin.", theta.String(), L(theta))
+	} else if spsa.LastLoss != L(theta) {
+		t.Error("LastLoss isn't the loss at theta after a kick.", spsa.LastLoss, L(theta))
+	}
+}
+
+func TestSPSATemperature(t *testing.T) {
+	// A double well with the start in the worse basin at x = 1 and the better
+	// one around x = -1
+	L := func(v Vector) float64 {
+		return math.Pow(v[0]*v[0]-1, 2) + .3*v[0] + v[1]*v[1]
+	}
+	newSPSA := func(seed int64) *SPSA {
+		return &SPSA{
+			L:     L,
+			C:     NoConstraints,
+			Theta: Vector{1, 0},
+			Ak:    StandardAk(2, 10, .602),
+			Ck:    StandardCk(.6, .101),
+			Delta: NewSeededBernoulli(1, seed),
+		}
+	}
+
+	var plain, annealed int
+	for seed := int64(0); seed < 300; seed++ {
+		if newSPSA(seed).Run(200)[0] < 0 {
+			plain++
+		}
+		spsa := newSPSA(seed)
+		spsa.Temperature = StandardCk(10, 1)
+		if spsa.Run(200)[0] < 0 {
+			annealed++
+		}
+	}
+
+	if annealed <= plain {
+		t.Error("Metropolis acceptance didn't escape the worse basin more often than plain SPSA.", annealed, plain)
+	}
+}
+
+func TestSPSAAnnealSource(t *testing.T) {
+	run := func() *SPSA {
+		spsa := &SPSA{
+			L:            AbsoluteSum,
+			C:            NoConstraints,
+			Theta:        Vector{1, -1, 1},
+			Ak:           StandardAk(.5, 10, .602),
+			Ck:           StandardCk(.1, .101),
+			Delta:        NewSeededBernoulli(1, 3),
+			Temperature:  StandardCk(1, 1),
+			AnnealSource: rand.New(rand.NewSource(5)),
+			TrackLoss:    true,
+		}
+		spsa.Run(50)
+		return spsa
+	}
+	a, b := run(), run()
+
+	if !reflect.DeepEqual(a.Theta, b.Theta) {
+		t.Error("Seeded annealed runs aren't reproducible.", a.Theta.String(), b.Theta.String())
+	} else if a.Evaluations() != 3*50+1 {
+		t.Error("TrackLoss didn't reuse the Metropolis loss.", a.Evaluations())
+	} else if a.LastLoss != AbsoluteSum(a.Theta) {
+		t.Error("LastLoss isn't the loss at theta.", a.LastLoss)
+	}
+}
+
+func TestEstimateConvergenceRate(t *testing.T) {
+	history := make([]float64, 1000)
+	for i := range history {
+		history[i] = 3 / float64(i+1) * (1 + .1*rand.NormFloat64())
+	}
+
+	if r := EstimateConvergenceRate(history); !near(r, -1, .05) {
+		t.Error("EstimateConvergenceRate isn't near -1 for a 1/k loss.", r)
+	} else if r := EstimateConvergenceRate([]float64{1}); !math.IsNaN(r) {
+		t.Error("EstimateConvergenceRate of a single loss isn't NaN.", r)
+	}
+}
+
+func TestMaybeRestart(t *testing.T) {
+	spsa := &SPSA{
+		L:           AbsoluteSum,
+		C:           NoConstraints,
+		Theta:       Vector{5, 5},
+		Ak:          StandardAk(.1, 10, .602),
+		Ck:          StandardCk(.1, .101),
+		Delta:       Bernoulli{1},
+		RestartSize: 2,
+		TrackLoss:   true,
+	}
+
+	if spsa.MaybeRestart(.1) {
+		t.Error("MaybeRestart kicked on its first call.")
+	}
+	spsa.Run(20)
+	if spsa.MaybeRestart(.1) {
+		t.Error("MaybeRestart kicked while the loss was improving.")
+	}
+
+	// Running no rounds simulates a stalled Run call.
+	before := spsa.Theta.Copy()
+	spsa.Run(0)
+	if !spsa.MaybeRestart(.1) {
+		t.Error("MaybeRestart didn't kick a stalled run.")
+	} else if d := spsa.Theta.Distance(before); !near(d, 2, 1e-9) {
+		t.Error("MaybeRestart didn't kick by RestartSize.", d)
+	} else if spsa.LastLoss != AbsoluteSum(spsa.Theta) {
+		t.Error("MaybeRestart left LastLoss stale after a kick.", spsa.LastLoss)
+	}
+
+	// An improving run from the kicked theta doesn't kick again.
+	spsa.Run(20)
+	if spsa.MaybeRestart(.1) {
+		t.Error("MaybeRestart kicked again after an improving run.")
+	}
+}
+
+func TestLowSNR(t *testing.T) {
+	quadratic := func(v Vector) float64 {
+		return math.Pow(v[0]-1, 2) + math.Pow(v[1]-1, 2)
+	}
+	spsa := &SPSA{
+		L:     WithNoise(quadratic, .1),
+		C:     NoConstraints,
+		Theta: Vector{4, -1},
+		Ak:    StandardAk(.1, 10, .602),
+		Ck:    StandardCk(.1, .101),
+		Delta: Bernoulli{1},
+	}
+	if snr := spsa.GradientSNR(30); snr < 2 {
+		t.Error("GradientSNR far from the optimum is too low.", snr)
+	}
+
+	var rounds int
+	spsa.RunUntil(Any{
+		LowSNR{SPSA: spsa, Every: 10, Samples: 30, Threshold: .5},
+		ConvergenceFunc(func(round int, theta Vector, loss float64) bool {
+			rounds = round
+			return round >= 10000
+		}),
+	})
+
+	if rounds >= 10000 {
+		t.Error("LowSNR didn't stop the run.")
+	} else if d := spsa.Theta.Distance(Vector{1, 1}); d > .5 {
+		t.Error("LowSNR stopped far from the optimum.", rounds, spsa.Theta.String())
+	}
+}
@@ -0,0 +1,114 @@
+package spsa
+
+import (
+	"math"
+	"math/rand"
+)
+
+//********** Direction Samplers ***********
+
+// A DirectionSampler produces whole perturbation vectors of dimension dim,
+// whose coordinates need not be independent, in place of a coordinate-wise
+// PerturbationDistribution. Its directions d must satisfy E[d d^T] = I.
+//
+// With a DirectionSampler, the gradient is reconstructed by projecting onto the
+// direction instead of dividing by it: g = (L(theta + ck*d) - L(theta - ck*d)) / (2 ck) * d.
+// For bernoulli +/- 1 perturbations 1/d_i = d_i, so the two forms agree.
+type DirectionSampler interface {
+	SampleDirection(dim int) Vector
+}
+
+// A DirectionSampler that draws a random orthonormal basis by orthonormalizing
+// gaussian vectors, and then returns its vectors in turn, scaled by sqrt(dim),
+// before drawing a new basis. Over each run of dim rounds, the perturbations
+// cover every direction exactly once, which conditions rotated problems better
+// than axis-aligned perturbations. The zero value uses the global random
+// number generator.
+type OrthonormalDirections struct {
+	rng   *rand.Rand
+	basis []Vector
+}
+
+// Create an OrthonormalDirections sampler with a private generator seeded by seed.
+func NewOrthonormalDirections(seed int64) *OrthonormalDirections {
+	return &OrthonormalDirections{rng: rand.New(rand.NewSource(seed))}
+}
+
+func (od *OrthonormalDirections) SampleDirection(dim int) Vector {
+	if len(od.basis) == 0 || len(od.basis[0]) != dim {
+		od.basis = od.randomBasis(dim)
+	}
+	d := od.basis[len(od.basis)-1]
+	od.basis = od.basis[:len(od.basis)-1]
+	return d.Scale(math.Sqrt(float64(dim)))
+}
+
+// Draw a random orthonormal basis of dimension dim by Gram-Schmidt
+// orthonormalization of gaussian vectors.
+func (od *OrthonormalDirections) randomBasis(dim int) []Vector {
+	basis := make([]Vector, 0, dim)
+	for len(basis) < dim {
+		v := make(Vector, dim)
+		for i := range v {
+			v[i] = od.norm()
+		}
+		for _, b := range basis {
+			v = v.AddScaled(b, -v.Dot(b))
+		}
+		// Redraw the rare nearly dependent vector
+		if v.Norm() > 1e-6 {
+			basis = append(basis, v.Normalize())
+		}
+	}
+	return basis
+}
+
+func (od *OrthonormalDirections) norm() float64 {
+	if od.rng != nil {
+		return od.rng.NormFloat64()
+	}
+	return rand.NormFloat64()
+}
+
+// A DirectionSampler of bernoulli +/- 1 perturbations whose signs are shared
+// within groups of coordinates: Groups[i] is the group of coordinate i, and one
+// sign is drawn per group for all of its members. Coordinates past the end of
+// Groups are perturbed independently. Since E[d d^T] is not the identity for
+// groups of several coordinates, the gradient estimate of a coordinate is the
+// sum of its group's gradient in expectation, which suits coordinates that
+// should move together. The signs are drawn from Source, or from the global
+// generator if it is nil.
+type GroupedBernoulli struct {
+	Groups []int
+	Source Source
+}
+
+func (gb GroupedBernoulli) SampleDirection(dim int) Vector {
+	var src Source = globalSource{}
+	if gb.Source != nil {
+		src = gb.Source
+	}
+	signs := make(map[int]float64)
+	d := make(Vector, dim)
+	for i := range d {
+		if i >= len(gb.Groups) {
+			d[i] = bernoulliSign(src)
+			continue
+		}
+		s, ok := signs[gb.Groups[i]]
+		if !ok {
+			s = bernoulliSign(src)
+			signs[gb.Groups[i]] = s
+		}
+		d[i] = s
+	}
+	return d
+}
+
+// A uniformly random sign, -1 or 1, drawn from src.
+func bernoulliSign(src Source) float64 {
+	if src.Float64() > .5 {
+		return 1
+	}
+	return -1
+}
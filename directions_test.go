@@ -0,0 +1,102 @@
+package spsa
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestOrthonormalDirections(t *testing.T) {
+	od := NewOrthonormalDirections(1)
+	dirs := make([]Vector, 4)
+	for i := range dirs {
+		dirs[i] = od.SampleDirection(4)
+	}
+
+	for i, a := range dirs {
+		for j, b := range dirs {
+			want := 0.0
+			if i == j {
+				want = 4
+			}
+			if !near(a.Dot(b), want, 1e-9) {
+				t.Error("OrthonormalDirections didn't cover an orthogonal basis scaled by sqrt(dim).", i, j, a.Dot(b))
+			}
+		}
+	}
+}
+
+func TestSPSADirectionSampler(t *testing.T) {
+	// A quadratic with a spread of curvatures along a rotated basis
+	dim, rounds, runs := 10, 200, 30
+	rotation := NewOrthonormalDirections(99).randomBasis(dim)
+	L := func(v Vector) (a float64) {
+		for i, r := range rotation {
+			x := r.Dot(v)
+			a += float64(i+1) * x * x
+		}
+		return a
+	}
+
+	newSPSA := func() *SPSA {
+		theta := make(Vector, dim)
+		for i := range theta {
+			theta[i] = 1
+		}
+		return &SPSA{
+			L:     L,
+			C:     NoConstraints,
+			Theta: theta,
+			Ak:    StandardAk(.02, float64(rounds)/10, .602),
+			Ck:    StandardCk(.1, .101),
+		}
+	}
+
+	var bernoulli, orthonormal float64
+	for seed := int64(0); seed < int64(runs); seed++ {
+		a := newSPSA()
+		a.Delta = NewSeededBernoulli(1, seed)
+		bernoulli += L(a.Run(rounds)) / float64(runs)
+
+		b := newSPSA()
+		b.DirectionSampler = NewOrthonormalDirections(seed)
+		if err := b.Validate(); err != nil {
+			t.Fatal("SPSA with a DirectionSampler and no Delta didn't validate.", err)
+		}
+		orthonormal += L(b.Run(rounds)) / float64(runs)
+	}
+
+	if orthonormal >= bernoulli {
+		t.Error("Orthonormal directions didn't improve on bernoulli perturbations on a rotated quadratic.", orthonormal, bernoulli)
+	}
+}
+
+func TestGroupedBernoulli(t *testing.T) {
+	gb := GroupedBernoulli{Groups: []int{0, 1, 0, 2, 1}}
+	var differ bool
+	for i := 0; i < 100; i++ {
+		d := gb.SampleDirection(6)
+		for _, v := range d {
+			if v != 1 && v != -1 {
+				t.Fatal("GroupedBernoulli isn't +/- 1.", d.String())
+			}
+		}
+		if d[0] != d[2] || d[1] != d[4] {
+			t.Fatal("GroupedBernoulli coordinates in a group don't share a sign.", d.String())
+		}
+		if d[0] != d[1] {
+			differ = true
+		}
+	}
+	if !differ {
+		t.Error("GroupedBernoulli groups don't have independent signs.")
+	}
+
+	a := GroupedBernoulli{Groups: []int{0, 1, 0}, Source: rand.New(rand.NewSource(3))}
+	b := GroupedBernoulli{Groups: []int{0, 1, 0}, Source: rand.New(rand.NewSource(3))}
+	for i := 0; i < 20; i++ {
+		if da, db := a.SampleDirection(4), b.SampleDirection(4); !reflect.DeepEqual(da, db) {
+			t.Fatal("GroupedBernoulli with equally seeded sources differ.", da.String(), db.String())
+		}
+	}
+}
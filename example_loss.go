@@ -2,6 +2,7 @@ package spsa
 
 import (
 	"math"
+	"math/rand"
 )
 
 // Basic absolute sum loss function which is used for testing
@@ -18,3 +19,60 @@ func Rosenbrock(v Vector) (a float64) {
 	}
 	return a
 }
+
+// Create a Huber loss centered at the origin. It is quadratic within delta of
+// zero and linear outside, summed over the coordinates.
+func Huber(delta float64) LossFunction {
+	return func(v Vector) (a float64) {
+		for _, vv := range v {
+			if x := math.Abs(vv); x <= delta {
+				a += x * x / 2
+			} else {
+				a += delta * (x - delta/2)
+			}
+		}
+		return a
+	}
+}
+
+// Create a log-cosh loss centered at the origin, summing log(cosh(x / scale))
+// over the coordinates. It behaves like a quadratic near zero and like an
+// absolute value far away.
+func LogCosh(scale float64) LossFunction {
+	return func(v Vector) (a float64) {
+		for _, vv := range v {
+			x := math.Abs(vv / scale)
+			a += x + math.Log1p(math.Exp(-2*x)) - math.Ln2
+		}
+		return a
+	}
+}
+
+// The Rastrigin function, a multimodal benchmark with many local minima and its
+// global minimum of 0 at the origin. A single SPSA run only reaches the global
+// minimum from a start near the origin; far starts need restarts.
+func Rastrigin(v Vector) (a float64) {
+	a = 10 * float64(len(v))
+	for _, vv := range v {
+		a += vv*vv - 10*math.Cos(2*math.Pi*vv)
+	}
+	return a
+}
+
+// Create the quadratic loss 0.5 theta^T H theta - b^T theta for a symmetric
+// positive-definite H, whose minimum is at H^-1 b and whose condition number
+// is that of H. This makes test problems of any conditioning.
+func QuadraticHessian(H Matrix, b Vector) LossFunction {
+	H, b = H.Copy(), b.Copy()
+	return func(theta Vector) float64 {
+		return .5*theta.Dot(H.MulVec(theta)) - b.Dot(theta)
+	}
+}
+
+// Wrap L with additive gaussian noise of standard deviation sigma, making it a
+// stochastic loss function for testing.
+func WithNoise(L LossFunction, sigma float64) LossFunction {
+	return func(v Vector) float64 {
+		return L(v) + sigma*rand.NormFloat64()
+	}
+}
@@ -0,0 +1,152 @@
+package spsa
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+)
+
+//********** Loss function helpers ***********
+
+// Evaluate L at each of thetas in order. This is useful for line searches
+// and grid scans outside of the main SPSA loop.
+func EvaluateAll(L LossFunction, thetas []Vector) []float64 {
+	losses := make([]float64, len(thetas))
+	for i, theta := range thetas {
+		losses[i] = L(theta)
+	}
+	return losses
+}
+
+// Evaluate L at each of thetas using at most workers concurrent goroutines.
+// The results are in the same order as thetas. L must be safe to call
+// concurrently from multiple goroutines.
+func EvaluateAllParallel(L LossFunction, thetas []Vector, workers int) []float64 {
+	if workers < 1 {
+		workers = 1
+	}
+
+	losses := make([]float64, len(thetas))
+	pool := NewWorkerPool(workers)
+
+	var wg sync.WaitGroup
+	for i, theta := range thetas {
+		i, theta := i, theta
+		wg.Add(1)
+		pool.Submit(func() {
+			defer wg.Done()
+			losses[i] = L(theta)
+		})
+	}
+	wg.Wait()
+
+	return losses
+}
+
+// Wrap a stochastic loss function L so that each call averages reps evaluations,
+// reducing the variance of the loss by a factor of reps.
+func Smoothed(L LossFunction, reps int) LossFunction {
+	return func(theta Vector) (loss float64) {
+		for i := 0; i < reps; i++ {
+			loss += L(theta)
+		}
+		return loss / float64(reps)
+	}
+}
+
+// Combine several loss functions into their weighted sum, for scalarized
+// multi-objective optimization. It panics if the number of weights doesn't
+// match the number of losses.
+func WeightedSum(weights []float64, losses ...LossFunction) LossFunction {
+	if len(weights) != len(losses) {
+		panic(fmt.Sprintf("spsa: %d weights given for %d loss functions", len(weights), len(losses)))
+	}
+	weights = Vector(weights).Copy()
+
+	return func(theta Vector) (loss float64) {
+		for i, L := range losses {
+			loss += weights[i] * L(theta)
+		}
+		return loss
+	}
+}
+
+// Wrap L with a quadratic penalty of weight times the squared distance of theta
+// outside the bounds bc, instead of clamping it. This keeps the loss landscape
+// smooth near the boundaries. Note that the penalized minimum of a loss whose
+// unconstrained minimum is outside the bounds sits just outside the boundary,
+// closer as weight grows.
+func PenalizedLoss(L LossFunction, bc BoundedConstraints, weight float64) LossFunction {
+	return func(theta Vector) float64 {
+		var penalty float64
+		for i, t := range theta {
+			if t < bc[i].Lower {
+				penalty += math.Pow(bc[i].Lower-t, 2)
+			} else if t > bc[i].Upper {
+				penalty += math.Pow(t-bc[i].Upper, 2)
+			}
+		}
+		return L(theta) + weight*penalty
+	}
+}
+
+// Wrap a deterministic, expensive L with a cache of its results. The cache is
+// keyed on theta.Quantize(step), so thetas that round to the same multiples of
+// step share the result of the first one evaluated, while nearby thetas on either
+// side of a rounding boundary don't. A non-positive step caches exact thetas only.
+// The returned loss function is safe for concurrent use if L is.
+func Memoize(L LossFunction, step float64) LossFunction {
+	var mu sync.Mutex
+	cache := make(map[string]float64)
+
+	return func(theta Vector) float64 {
+		key := fingerprint(theta.Quantize(step))
+
+		mu.Lock()
+		loss, ok := cache[key]
+		mu.Unlock()
+		if ok {
+			return loss
+		}
+
+		loss = L(theta)
+		mu.Lock()
+		cache[key] = loss
+		mu.Unlock()
+		return loss
+	}
+}
+
+// Infer the dimension L expects by evaluating it at zero vectors of increasing
+// length up to maxDim. The smallest length at which L returns without panicking
+// (e.g. with an index out of range) is returned. L should be cheap and free of
+// side effects; a loss that accepts any length reports a dimension of 1.
+func ProbeDimension(L LossFunction, maxDim int) (int, error) {
+	for n := 1; n <= maxDim; n++ {
+		if probe(L, make(Vector, n)) {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("spsa: loss function panics for every dimension up to %d", maxDim)
+}
+
+// Whether L evaluates theta without panicking.
+func probe(L LossFunction, theta Vector) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	L(theta)
+	return true
+}
+
+// A map key holding the exact bits of every element of a.
+func fingerprint(a Vector) string {
+	b := make([]byte, 8*len(a))
+	for i, v := range a {
+		binary.LittleEndian.PutUint64(b[8*i:], math.Float64bits(v))
+	}
+	return string(b)
+}
@@ -0,0 +1,156 @@
+package spsa
+
+import (
+	"math"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEvaluateAll(t *testing.T) {
+	thetas := []Vector{{1, 2}, {-3, 4}, {0, 0}}
+	losses := EvaluateAll(AbsoluteSum, thetas)
+
+	if !reflect.DeepEqual(losses, []float64{3, 7, 0}) {
+		t.Error("EvaluateAll did not operate correctly.", losses)
+	}
+}
+
+func TestEvaluateAllParallel(t *testing.T) {
+	thetas := make([]Vector, 20)
+	for i := range thetas {
+		thetas[i] = Vector{float64(i), -1}
+	}
+
+	var active, peak int32
+	L := func(v Vector) float64 {
+		n := atomic.AddInt32(&active, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&active, -1)
+		return AbsoluteSum(v)
+	}
+
+	losses := EvaluateAllParallel(L, thetas, 3)
+
+	if !reflect.DeepEqual(losses, EvaluateAll(AbsoluteSum, thetas)) {
+		t.Error("EvaluateAllParallel didn't match sequential evaluation.", losses)
+	}
+	if peak > 3 {
+		t.Error("EvaluateAllParallel exceeded the requested worker count.", peak)
+	}
+}
+
+func TestSmoothed(t *testing.T) {
+	noisy := WithNoise(AbsoluteSum, 1)
+	smoothed := Smoothed(noisy, 10)
+	theta := Vector{1, -1}
+
+	var raw, smooth OnlineStats
+	for i := 0; i < 2000; i++ {
+		raw.Add(noisy(theta))
+		smooth.Add(smoothed(theta))
+	}
+
+	if math.Abs(smooth.Mean()-2) > .05 {
+		t.Error("Smoothed loss was biased.", smooth.Mean())
+	}
+	if ratio := smooth.Var() / raw.Var(); ratio < .05 || ratio > .2 {
+		t.Error("Smoothed loss didn't have about 1/reps the variance.", ratio)
+	}
+}
+
+func TestWeightedSum(t *testing.T) {
+	quadratic := func(v Vector) float64 { return v.Dot(v) }
+	L := WeightedSum([]float64{2, .5}, AbsoluteSum, quadratic)
+
+	if loss := L(Vector{1, -2}); loss != 2*3+.5*5 {
+		t.Error("WeightedSum didn't weight the losses.", loss)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("WeightedSum didn't panic on a count mismatch.")
+		}
+	}()
+	WeightedSum([]float64{1}, AbsoluteSum, quadratic)
+}
+
+func TestPenalizedLoss(t *testing.T) {
+	// The unconstrained minimum at 2 is outside of the box [0,1].
+	L := func(v Vector) float64 {
+		return math.Pow(v[0]-2, 2)
+	}
+	bc := BoundedConstraints{{0, 1}}
+	penalized := PenalizedLoss(L, bc, 100)
+
+	if penalized(Vector{.5}) != L(Vector{.5}) {
+		t.Error("PenalizedLoss penalized a point inside the bounds.")
+	}
+
+	theta := Optimize(penalized, Vector{.5}, 1000, .05, .01)
+	if math.Abs(theta[0]-1) > .02 {
+		t.Error("PenalizedLoss minimum isn't at the boundary.", theta.String())
+	}
+}
+
+func TestMemoize(t *testing.T) {
+	evals := 0
+	L := Memoize(func(v Vector) float64 {
+		evals++
+		return AbsoluteSum(v)
+	}, .01)
+
+	if L(Vector{1, 2}) != 3 || L(Vector{1, 2}) != 3 || L(Vector{1.001, 2}) != 3 {
+		t.Error("Memoize didn't return the cached loss.")
+	} else if evals != 1 {
+		t.Error("Memoize didn't hit the cache for repeated thetas.", evals)
+	}
+
+	L(Vector{1, 2.5})
+	L(Vector{-1, 2})
+	if evals != 3 {
+		t.Error("Memoize hit the cache for distinct thetas.", evals)
+	}
+}
+
+func TestSPSACacheEvaluations(t *testing.T) {
+	newSPSA := func(cache bool) *SPSA {
+		return &SPSA{
+			L:                AbsoluteSum,
+			C:                NoConstraints,
+			Theta:            Vector{1, 1, 1},
+			Ak:               SliceGain([]float64{.01, 0}),
+			Ck:               SliceGain([]float64{.1}),
+			Delta:            NewDeterministicBernoulli(1, 1, -1, 1),
+			CacheEvaluations: cache,
+		}
+	}
+
+	uncached, cached := newSPSA(false), newSPSA(true)
+	a, b := uncached.Run(10), cached.Run(10)
+
+	if !reflect.DeepEqual(a, b) {
+		t.Error("Caching evaluations changed the result.", a.String(), b.String())
+	} else if uncached.Evaluations() != 20 {
+		t.Error("SPSA without a cache didn't evaluate every perturbation.", uncached.Evaluations())
+	} else if cached.Evaluations() != 4 {
+		t.Error("Cache hits didn't reduce the evaluation count.", cached.Evaluations())
+	}
+}
+
+func TestProbeDimension(t *testing.T) {
+	L := func(v Vector) float64 { return v[0] + v[4] }
+
+	if n, err := ProbeDimension(L, 10); err != nil || n != 5 {
+		t.Error("ProbeDimension didn't infer the dimension.", n, err)
+	} else if _, err := ProbeDimension(L, 4); err == nil {
+		t.Error("ProbeDimension didn't fail below the dimension.")
+	}
+}
@@ -0,0 +1,137 @@
+package spsa
+
+import (
+	"math"
+)
+
+// A simple dense real matrix stored as a slice of row vectors, e.g. for the
+// Hessian estimates of second-order SPSA. All operations are out-of-place.
+type Matrix []Vector
+
+// Copy m to a new matrix.
+func (m Matrix) Copy() Matrix {
+	c := make(Matrix, len(m))
+	for i, row := range m {
+		c[i] = row.Copy()
+	}
+	return c
+}
+
+// Transpose of m. (out of place)
+func (m Matrix) Transpose() Matrix {
+	if len(m) == 0 {
+		return Matrix{}
+	}
+	t := make(Matrix, len(m[0]))
+	for j := range t {
+		t[j] = make(Vector, len(m))
+		for i, row := range m {
+			t[j][i] = row[j]
+		}
+	}
+	return t
+}
+
+// Product of m and the column vector v. (out of place)
+func (m Matrix) MulVec(v Vector) Vector {
+	c := make(Vector, len(m))
+	for i, row := range m {
+		c[i] = row.Dot(v)
+	}
+	return c
+}
+
+// Symmetric part of the square matrix m, (m + m^T) / 2. (out of place)
+func (m Matrix) Symmetrize() Matrix {
+	s := m.Copy()
+	for i := range m {
+		for j := range m {
+			s[i][j] = (m[i][j] + m[j][i]) / 2
+		}
+	}
+	return s
+}
+
+// Project the square matrix m onto the symmetric positive-definite matrices by
+// symmetrizing it and raising every eigenvalue below floor up to floor, where
+// floor > 0. This keeps a Hessian estimate safely invertible. (out of place)
+func (m Matrix) ProjectPSD(floor float64) Matrix {
+	vals, vecs := m.Symmetrize().eigen()
+	for i, v := range vals {
+		vals[i] = math.Max(v, floor)
+	}
+
+	// Reconstruct vecs * diag(vals) * vecs^T
+	n := len(m)
+	p := make(Matrix, n)
+	for i := range p {
+		p[i] = make(Vector, n)
+		for j := range p[i] {
+			for k, v := range vals {
+				p[i][j] += vecs[i][k] * v * vecs[j][k]
+			}
+		}
+	}
+	return p
+}
+
+// Eigenvalues and eigenvectors (as the columns of vecs) of the symmetric matrix m
+// by the cyclic Jacobi eigenvalue method.
+func (m Matrix) eigen() (vals Vector, vecs Matrix) {
+	n := len(m)
+	a := m.Copy()
+	vecs = make(Matrix, n)
+	for i := range vecs {
+		vecs[i] = make(Vector, n)
+		vecs[i][i] = 1
+	}
+
+	for sweep := 0; sweep < 100; sweep++ {
+		var off, diag float64
+		for i := 0; i < n; i++ {
+			diag += a[i][i] * a[i][i]
+			for j := i + 1; j < n; j++ {
+				off += a[i][j] * a[i][j]
+			}
+		}
+		if off <= 1e-30*diag || off == 0 {
+			break
+		}
+
+		for p := 0; p < n; p++ {
+			for q := p + 1; q < n; q++ {
+				if a[p][q] == 0 {
+					continue
+				}
+
+				// Rotate by the angle that zeroes a[p][q]
+				theta := (a[q][q] - a[p][p]) / (2 * a[p][q])
+				t := 1 / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				if theta < 0 {
+					t = -t
+				}
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+
+				for k := 0; k < n; k++ {
+					akp, akq := a[k][p], a[k][q]
+					a[k][p], a[k][q] = c*akp-s*akq, s*akp+c*akq
+				}
+				for k := 0; k < n; k++ {
+					apk, aqk := a[p][k], a[q][k]
+					a[p][k], a[q][k] = c*apk-s*aqk, s*apk+c*aqk
+				}
+				for k := 0; k < n; k++ {
+					vkp, vkq := vecs[k][p], vecs[k][q]
+					vecs[k][p], vecs[k][q] = c*vkp-s*vkq, s*vkp+c*vkq
+				}
+			}
+		}
+	}
+
+	vals = make(Vector, n)
+	for i := range vals {
+		vals[i] = a[i][i]
+	}
+	return vals, vecs
+}
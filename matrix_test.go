@@ -0,0 +1,89 @@
+package spsa
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatrixCopy(t *testing.T) {
+	a := Matrix{{1, 2}, {3, 4}}
+	b := a.Copy()
+	b[0][0] = 10
+
+	if !reflect.DeepEqual(a, Matrix{{1, 2}, {3, 4}}) {
+		t.Error("Matrix Copy did not copy correctly.")
+	}
+}
+
+func TestMatrixTranspose(t *testing.T) {
+	a := Matrix{{1, 2, 3}, {4, 5, 6}}
+	if !reflect.DeepEqual(a.Transpose(), Matrix{{1, 4}, {2, 5}, {3, 6}}) {
+		t.Error("Matrix Transpose did not operate correctly.")
+	}
+}
+
+func TestMatrixMulVec(t *testing.T) {
+	a := Matrix{{1, 2, 3}, {4, 5, 6}}
+	if v := a.MulVec(Vector{1, 0, -1}); !reflect.DeepEqual(v, Vector{-2, -2}) {
+		t.Error("Matrix MulVec did not operate correctly.", v.String())
+	}
+}
+
+func TestMatrixSymmetrize(t *testing.T) {
+	a := Matrix{{1, 2}, {4, 3}}
+	b := a.Symmetrize()
+
+	if !reflect.DeepEqual(a, Matrix{{1, 2}, {4, 3}}) {
+		t.Error("Symmetrize did not run out of place.")
+	} else if !reflect.DeepEqual(b, Matrix{{1, 3}, {3, 3}}) {
+		t.Error("Symmetrize did not operate correctly.", b)
+	}
+}
+
+func TestMatrixEigen(t *testing.T) {
+	a := Matrix{{4, 1, 2}, {1, 3, 0}, {2, 0, 5}}
+	vals, vecs := a.eigen()
+
+	// Check a * v = lambda * v for each eigenpair
+	for k, lambda := range vals {
+		for i := range a {
+			var av float64
+			for j := range a {
+				av += a[i][j] * vecs[j][k]
+			}
+			if !near(av, lambda*vecs[i][k], 1e-9) {
+				t.Error("Matrix eigen didn't find an eigenpair.", k, lambda)
+			}
+		}
+	}
+}
+
+func TestMatrixProjectPSD(t *testing.T) {
+	// Indefinite, with eigenvalues 3 and -1
+	a := Matrix{{1, 2}, {2, 1}}
+	p := a.ProjectPSD(.1)
+
+	if !near(p[0][1], p[1][0], 1e-12) {
+		t.Error("ProjectPSD result isn't symmetric.", p)
+	}
+	vals, _ := p.eigen()
+	for _, v := range vals {
+		if v < .1-1e-9 {
+			t.Error("ProjectPSD result has an eigenvalue below the floor.", vals)
+		}
+	}
+	// Leading principal minors are positive
+	if p[0][0] <= 0 || p[0][0]*p[1][1]-p[0][1]*p[1][0] <= 0 {
+		t.Error("ProjectPSD result isn't positive definite.", p)
+	}
+
+	spd := Matrix{{2, 1}, {1, 2}}
+	q := spd.ProjectPSD(.1)
+	for i := range q {
+		for j := range q {
+			if !near(q[i][j], spd[i][j], 1e-9) {
+				t.Error("ProjectPSD changed an SPD matrix.", q)
+			}
+		}
+	}
+}
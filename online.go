@@ -0,0 +1,31 @@
+package spsa
+
+//********** Online SPSA ***********
+
+// A loss function of theta on a single observation, e.g. one (x, y) pair of a
+// data stream.
+type OnlineLossFunction func(theta Vector, obs interface{}) float64
+
+// Run exactly one round of SPSA against the observation obs using OnlineL, and
+// return the current Theta value. Feeding a stream of observations one at a time
+// makes SPSA an online learner; use gains that don't decay to zero (e.g. alpha = 0)
+// to track a moving target. L is restored after the round. Losses cached from
+// earlier observations (CacheEvaluations, Temperature, LastLoss) are discarded.
+// Like Run, a round that diverges beyond DivergenceLimit is undone and checkpoints
+// are written every CheckpointEvery rounds. It panics if AsyncL is set, since
+// AsyncL would be evaluated instead of OnlineL.
+func (spsa *SPSA) Feed(obs interface{}) Vector {
+	if spsa.AsyncL != nil {
+		panic("spsa: Feed with AsyncL set")
+	}
+
+	L := spsa.L
+	defer func() { spsa.L = L }()
+	spsa.L = func(theta Vector) float64 {
+		return spsa.OnlineL(theta, obs)
+	}
+	spsa.evalCache, spsa.annealTheta, spsa.lossTheta = nil, nil, nil
+
+	spsa.step(false)
+	return spsa.Theta
+}
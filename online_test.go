@@ -0,0 +1,89 @@
+package spsa
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+type observation struct {
+	x, y float64
+}
+
+func TestFeed(t *testing.T) {
+	spsa := &SPSA{
+		OnlineL: func(theta Vector, obs interface{}) float64 {
+			o := obs.(observation)
+			return math.Pow(theta[0]*o.x-o.y, 2)
+		},
+		C:     NoConstraints,
+		Theta: Vector{0},
+		Ak:    StandardAk(.05, 0, 0),
+		Ck:    StandardCk(.05, 0),
+		Delta: Bernoulli{1},
+	}
+
+	// The target slope moves from 1 to 3 over the stream.
+	for i := 0; i < 4000; i++ {
+		w := 1 + 2*float64(i)/4000
+		x := rand.NormFloat64()
+		spsa.Feed(observation{x, w * x})
+
+		if i == 1999 && !near(spsa.Theta[0], w, .2) {
+			t.Error("Online SPSA didn't track the target midway.", spsa.Theta[0], w)
+		}
+	}
+
+	if !near(spsa.Theta[0], 3, .2) {
+		t.Error("Online SPSA didn't track the moving target.", spsa.Theta[0])
+	} else if spsa.Evaluations() != 8000 {
+		t.Error("Feed didn't run exactly one round per observation.", spsa.Evaluations())
+	} else if spsa.L != nil {
+		t.Error("Feed didn't restore L.")
+	}
+}
+
+func TestFeedPanics(t *testing.T) {
+	spsa := &SPSA{
+		OnlineL: func(theta Vector, obs interface{}) float64 { panic("bad observation") },
+		C:       NoConstraints,
+		Theta:   Vector{0},
+		Ak:      StandardAk(.05, 0, 0),
+		Ck:      StandardCk(.05, 0),
+		Delta:   Bernoulli{1},
+	}
+	func() {
+		defer func() { recover() }()
+		spsa.Feed(nil)
+	}()
+	if spsa.L != nil {
+		t.Error("Feed didn't restore L after OnlineL panicked.")
+	}
+
+	spsa.AsyncL = func(v Vector) <-chan float64 { return nil }
+	defer func() {
+		if recover() == nil {
+			t.Error("Feed didn't panic with AsyncL set.")
+		}
+	}()
+	spsa.Feed(nil)
+}
+
+func TestFeedDivergence(t *testing.T) {
+	spsa := &SPSA{
+		OnlineL:         func(theta Vector, obs interface{}) float64 { return -theta.Dot(theta) },
+		C:               NoConstraints,
+		Theta:           Vector{1, 1},
+		Ak:              StandardAk(1, 10, .602),
+		Ck:              StandardCk(.1, .101),
+		Delta:           Bernoulli{1},
+		DivergenceLimit: 100,
+	}
+	for i := 0; i < 200; i++ {
+		spsa.Feed(nil)
+	}
+
+	if spsa.Theta.Norm() > 100 {
+		t.Error("Feed didn't undo rounds that diverged.", spsa.Theta.String())
+	}
+}
@@ -0,0 +1,23 @@
+package spsa
+
+//********** Worker Pool ***********
+
+// A Pool that runs each submitted task on its own goroutine, with at most
+// cap(wp) tasks running at once. Submit blocks while the pool is full.
+type WorkerPool chan bool
+
+// Create a WorkerPool running at most workers tasks at once.
+func NewWorkerPool(workers int) WorkerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	return make(WorkerPool, workers)
+}
+
+func (wp WorkerPool) Submit(task func()) {
+	wp <- true
+	go func() {
+		defer func() { <-wp }()
+		task()
+	}()
+}
@@ -0,0 +1,133 @@
+package spsa
+
+import (
+	"math/rand"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSPSAPool(t *testing.T) {
+	var active, overlaps int32
+	L := func(v Vector) float64 {
+		if atomic.AddInt32(&active, 1) > 1 {
+			atomic.AddInt32(&overlaps, 1)
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&active, -1)
+		return AbsoluteSum(v)
+	}
+
+	spsa := &SPSA{
+		L:     L,
+		C:     NoConstraints,
+		Theta: Vector{1, 1, 1},
+		Ak:    StandardAk(.1, 10, .602),
+		Ck:    StandardCk(.1, .101),
+		Delta: Bernoulli{1},
+		Pool:  NewWorkerPool(1),
+	}
+	spsa.Run(20)
+
+	if overlaps > 0 {
+		t.Error("A pool of size 1 didn't serialize the loss evaluations.", overlaps)
+	}
+}
+
+func TestSPSAAsyncL(t *testing.T) {
+	var pending, peak int32
+	AL := func(v Vector) <-chan float64 {
+		c := make(chan float64, 1)
+		if p := atomic.AddInt32(&pending, 1); p > atomic.LoadInt32(&peak) {
+			atomic.StoreInt32(&peak, p)
+		}
+		go func() {
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&pending, -1)
+			c <- AbsoluteSum(v)
+		}()
+		return c
+	}
+
+	newSPSA := func() *SPSA {
+		return &SPSA{
+			C:     NoConstraints,
+			Theta: Vector{1, 1, 1},
+			Ak:    StandardAk(.1, 10, .602),
+			Ck:    StandardCk(.1, .101),
+			Delta: NewSeededBernoulli(1, 7),
+		}
+	}
+	async := newSPSA()
+	async.AsyncL = AL
+	if err := async.Validate(); err != nil {
+		t.Fatal("SPSA with only an AsyncL didn't validate.", err)
+	}
+	synchronous := newSPSA()
+	synchronous.L = AbsoluteSum
+
+	if a, b := async.Run(10), synchronous.Run(10); !reflect.DeepEqual(a, b) {
+		t.Error("SPSA with an AsyncL didn't match the synchronous loss.", a.String(), b.String())
+	} else if peak != 2 {
+		t.Error("SPSA didn't launch both perturbations before awaiting them.", peak)
+	}
+}
+
+func TestSPSAParallelDirections(t *testing.T) {
+	// Random latencies shuffle the order in which the evaluations complete
+	L := func(v Vector) float64 {
+		time.Sleep(time.Duration(rand.Intn(500)) * time.Microsecond)
+		return Rosenbrock(v)
+	}
+	newSPSA := func(pool Pool) *SPSA {
+		return &SPSA{
+			L:          L,
+			C:          NoConstraints,
+			Theta:      Vector{.5, 1.5, -.3, .7},
+			Ak:         StandardAk(.001, 10, .602),
+			Ck:         StandardCk(.1, .101),
+			Delta:      WithSource(SegmentedUniform{.5, 1.5}, rand.New(rand.NewSource(3))),
+			Directions: 8,
+			Pool:       pool,
+		}
+	}
+
+	sequential := newSPSA(nil).EstimateGradient()
+	for i := 0; i < 2; i++ {
+		parallel := newSPSA(NewWorkerPool(4))
+		if grad := parallel.EstimateGradient(); !reflect.DeepEqual(grad, sequential) {
+			t.Error("The parallel gradient estimate wasn't bit-for-bit reproducible.", grad, sequential)
+		} else if parallel.Evaluations() != 16 {
+			t.Error("The parallel gradient estimate didn't record every evaluation.", parallel.Evaluations())
+		}
+	}
+}
+
+func TestWorkerPool(t *testing.T) {
+	var active, peak int32
+	pool := NewWorkerPool(2)
+	done := make(chan bool, 10)
+
+	for i := 0; i < 10; i++ {
+		pool.Submit(func() {
+			n := atomic.AddInt32(&active, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&active, -1)
+			done <- true
+		})
+	}
+	for i := 0; i < 10; i++ {
+		<-done
+	}
+
+	if peak > 2 {
+		t.Error("WorkerPool ran more tasks at once than its size.", peak)
+	}
+}
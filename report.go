@@ -0,0 +1,55 @@
+package spsa
+
+import (
+	"fmt"
+	"time"
+)
+
+//********** Run Reports ***********
+
+// A summary of one call to RunDetailed.
+type RunReport struct {
+	Theta     Vector  // Final theta
+	Loss      float64 // Loss at the final theta
+	BestTheta Vector  // Theta with the lowest loss seen, including the initial theta
+	BestLoss  float64 // Loss at BestTheta
+
+	Rounds      int // Rounds completed in this run
+	Evaluations int // Loss evaluations made in this run, including the monitoring ones
+
+	Reason   string        // Why the run stopped
+	Err      error         // The validation or divergence error, if the run stopped early
+	Duration time.Duration // Wall-clock time of the run
+}
+
+// Validate the SPSA instance and run many rounds of SPSA like RunChecked and
+// return a report of the run. A validation error is reported without running. The
+// loss at theta is evaluated before the run and after every round to find the
+// best theta, costing one extra loss evaluation per round (none if TrackLoss is set).
+func (spsa *SPSA) RunDetailed(rounds int) RunReport {
+	start, k0, evals0 := time.Now(), spsa.k, spsa.evals
+	if err := spsa.Validate(); err != nil {
+		return RunReport{Theta: spsa.Theta, Reason: err.Error(), Err: err}
+	}
+
+	report := RunReport{BestTheta: spsa.Theta.Copy(), BestLoss: spsa.evaluate(spsa.Theta)}
+	report.Loss = report.BestLoss
+	report.Err = spsa.run(rounds, func() error {
+		report.Loss = spsa.currentLoss()
+		if report.Loss < report.BestLoss {
+			report.BestTheta, report.BestLoss = spsa.Theta.Copy(), report.Loss
+		}
+		return nil
+	})
+
+	report.Theta = spsa.Theta
+	report.Rounds = spsa.k - k0
+	report.Evaluations = spsa.evals - evals0
+	report.Duration = time.Since(start)
+	if report.Err != nil {
+		report.Reason = report.Err.Error()
+	} else {
+		report.Reason = fmt.Sprintf("completed %d rounds", rounds)
+	}
+	return report
+}
@@ -0,0 +1,69 @@
+package spsa
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRunDetailed(t *testing.T) {
+	spsa := &SPSA{
+		L:     AbsoluteSum,
+		C:     NoConstraints,
+		Theta: Vector{1, 1, 1, 1, 1},
+		Ak:    StandardAk(1, 100, .602),
+		Ck:    StandardCk(.1, .101),
+		Delta: Bernoulli{1},
+	}
+	report := spsa.RunDetailed(100)
+
+	if report.Rounds != 100 || report.Err != nil || report.Reason == "" {
+		t.Error("RunDetailed didn't report completing its rounds.", report.Rounds, report.Reason)
+	}
+	if report.Evaluations != 301 || report.Evaluations != spsa.Evaluations() {
+		t.Error("RunDetailed didn't count the evaluations.", report.Evaluations)
+	}
+	if !reflect.DeepEqual(report.Theta, spsa.Theta) || report.Loss != AbsoluteSum(spsa.Theta) {
+		t.Error("RunDetailed didn't report the final theta and loss.", report.Theta, report.Loss)
+	}
+	if report.BestLoss > report.Loss || report.BestLoss != AbsoluteSum(report.BestTheta) {
+		t.Error("RunDetailed didn't report the best theta and loss.", report.BestTheta, report.BestLoss, report.Loss)
+	}
+	if report.Duration <= 0 {
+		t.Error("RunDetailed didn't measure the wall-clock time.", report.Duration)
+	}
+}
+
+func TestRunDetailedDivergence(t *testing.T) {
+	spsa := &SPSA{
+		L:               func(v Vector) float64 { return -v.Dot(v) },
+		C:               NoConstraints,
+		Theta:           Vector{1, 1},
+		Ak:              StandardAk(1, 10, .602),
+		Ck:              StandardCk(.1, .101),
+		Delta:           Bernoulli{1},
+		DivergenceLimit: 100,
+	}
+	report := spsa.RunDetailed(1000)
+
+	if report.Err == nil || report.Rounds >= 1000 || report.Reason != report.Err.Error() {
+		t.Error("RunDetailed didn't report the divergence.", report.Rounds, report.Reason)
+	} else if report.Loss != spsa.L(report.Theta) || report.BestLoss > report.Loss {
+		t.Error("RunDetailed didn't report the loss at the restored theta.", report.Theta, report.Loss)
+	}
+}
+
+func TestRunDetailedInvalid(t *testing.T) {
+	spsa := &SPSA{
+		L:     AbsoluteSum,
+		Theta: Vector{1, 1},
+		Ck:    StandardCk(.1, .101),
+		Delta: Bernoulli{1},
+	}
+	report := spsa.RunDetailed(10)
+
+	if report.Err == nil || report.Reason != report.Err.Error() {
+		t.Error("RunDetailed didn't report the validation error.", report.Reason)
+	} else if report.Rounds != 0 || spsa.Evaluations() != 0 {
+		t.Error("RunDetailed ran an invalid SPSA instance.", report.Rounds, spsa.Evaluations())
+	}
+}
@@ -0,0 +1,86 @@
+package spsa
+
+import (
+	"math/rand"
+	"sync"
+)
+
+//********** Random Restarts ***********
+
+// A helper function to optimize a multimodal loss function by running Optimize
+// from restarts random starting points drawn uniformly within bounds, and
+// returning the best final theta. The runs are constrained to bounds. Each restart
+// uses its own generator seeded by its index, so the result is reproducible.
+func OptimizeRestarts(L LossFunction, bounds BoundedConstraints, restarts, n int, a, c float64) Vector {
+	return OptimizeRestartsParallel(L, bounds, restarts, n, a, c, 1)
+}
+
+// A helper function like OptimizeRestarts that runs the restarts concurrently on
+// at most workers goroutines. Its result is the same as OptimizeRestarts.
+// L must be safe for concurrent use when workers > 1.
+func OptimizeRestartsParallel(L LossFunction, bounds BoundedConstraints, restarts, n int, a, c float64, workers int) Vector {
+	thetas, losses := runRestarts(L, bounds, restarts, n, a, c, workers)
+
+	_, best := Vector(losses).Min()
+	if best < 0 {
+		return nil
+	}
+	return thetas[best]
+}
+
+// A helper function like OptimizeRestarts that also summarizes the final thetas
+// of all the restarts by their per-coordinate mean and variance. Coordinates with
+// a high variance across restarts are poorly determined by the loss. The variance
+// is 0 with a single restart.
+func OptimizeRestartsStats(L LossFunction, bounds BoundedConstraints, restarts, n int, a, c float64) (best, mean, variance Vector) {
+	thetas, losses := runRestarts(L, bounds, restarts, n, a, c, 1)
+	if _, i := Vector(losses).Min(); i >= 0 {
+		best = thetas[i]
+	}
+
+	mean, variance = make(Vector, len(bounds)), make(Vector, len(bounds))
+	column := make(Vector, len(thetas))
+	for i := range bounds {
+		for j, theta := range thetas {
+			column[j] = theta[i]
+		}
+		mean[i] = column.Mean()
+		if len(column) > 1 {
+			variance[i] = column.Var()
+		}
+	}
+	return best, mean, variance
+}
+
+// Run the restarts on at most workers goroutines and return their final thetas
+// and losses in restart order.
+func runRestarts(L LossFunction, bounds BoundedConstraints, restarts, n int, a, c float64, workers int) ([]Vector, []float64) {
+	thetas := make([]Vector, restarts)
+	losses := make([]float64, restarts)
+	pool := NewWorkerPool(workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < restarts; i++ {
+		i := i
+		wg.Add(1)
+		pool.Submit(func() {
+			defer wg.Done()
+			thetas[i] = restart(L, bounds, n, a, c, int64(i))
+			losses[i] = L(thetas[i])
+		})
+	}
+	wg.Wait()
+
+	return thetas, losses
+}
+
+// Run one restart from a random point within bounds, seeded by seed.
+func restart(L LossFunction, bounds BoundedConstraints, n int, a, c float64, seed int64) Vector {
+	rng := rand.New(rand.NewSource(seed))
+	theta0 := make(Vector, len(bounds))
+	for i, b := range bounds {
+		theta0[i] = b.Lower + rng.Float64()*(b.Upper-b.Lower)
+	}
+
+	return optimize(L, theta0, n, a, c, NewSeededBernoulli(1, rng.Int63()), []ConstraintFunction{bounds.Constrain})
+}
@@ -0,0 +1,45 @@
+package spsa
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestOptimizeRestarts(t *testing.T) {
+	bounds := BoundedConstraints{{-5.12, 5.12}, {-5.12, 5.12}}
+	theta := OptimizeRestarts(Rastrigin, bounds, 64, 500, .005, .02)
+
+	if Rastrigin(theta) > 1.1 {
+		t.Error("Restarts didn't find a good basin of the Rastrigin function.", theta.String(), Rastrigin(theta))
+	}
+}
+
+func TestOptimizeRestartsParallel(t *testing.T) {
+	bounds := BoundedConstraints{{-5.12, 5.12}, {-5.12, 5.12}}
+	sequential := OptimizeRestarts(Rastrigin, bounds, 64, 500, .005, .02)
+	parallel := OptimizeRestartsParallel(Rastrigin, bounds, 64, 500, .005, .02, 4)
+
+	if !reflect.DeepEqual(sequential, parallel) {
+		t.Error("Parallel restarts didn't reproduce the sequential result.", sequential.String(), parallel.String())
+	}
+}
+
+func TestOptimizeRestartsStats(t *testing.T) {
+	// The first coordinate is well-determined and the second is flat
+	L := func(v Vector) float64 { return math.Pow(v[0]-1, 2) }
+	bounds := BoundedConstraints{{-5, 5}, {-5, 5}}
+	best, mean, variance := OptimizeRestartsStats(L, bounds, 16, 500, .1, .1)
+
+	if !reflect.DeepEqual(best, OptimizeRestarts(L, bounds, 16, 500, .1, .1)) {
+		t.Error("OptimizeRestartsStats didn't return the best restart.", best.String())
+	} else if !near(mean[0], 1, .05) {
+		t.Error("The mean of the well-determined coordinate isn't its optimum.", mean.String())
+	} else if variance[1] < 100*variance[0] {
+		t.Error("The flat coordinate didn't show a higher variance across restarts.", variance.String())
+	}
+
+	if _, _, variance := OptimizeRestartsStats(L, bounds, 1, 10, .1, .1); !reflect.DeepEqual(variance, Vector{0, 0}) {
+		t.Error("The variance of a single restart isn't 0.", variance.String())
+	}
+}
@@ -22,8 +22,11 @@
 package spsa
 
 import (
+	"errors"
+	"fmt"
 	"math"
 	"math/rand"
+	"sync"
 )
 
 //********** Type Definitions ************
@@ -32,6 +35,9 @@ import (
 // specified in ISSO.
 type GainSequence <-chan float64
 
+// Vector gain sequences are infinite iterators of per-coordinate gains.
+type VectorGainSequence <-chan Vector
+
 // A perturbation distribution is used to simultaneously perturb the otimization
 // criteria to approximate the loss function's gradient. It must have special
 // properties, the most restrictive is E[1/X] is bounded. This rules out
@@ -40,13 +46,37 @@ type PerturbationDistribution interface {
 	Sample() float64
 }
 
+// A source of uniform random numbers in [0, 1), such as a *rand.Rand or a custom
+// PCG or cryptographic generator. Distributions drawing from a Source can be
+// reproduced and made safe for concurrent use independently of math/rand.
+type Source interface {
+	Float64() float64
+}
+
+// A perturbation distribution that can draw its samples from any Source.
+// Bind it to a source with WithSource.
+type SourcedDistribution interface {
+	SampleFrom(src Source) float64
+}
+
 // A loss function is a vector-valued to real function. It will be minimized in SPSA.
 // (Negate maximization functions to act as Loss functions.)
 type LossFunction func(Vector) float64
 
+// An asynchronous loss function starts evaluating the loss at a vector and
+// returns a channel on which the loss is delivered, such as a request to a
+// remote service.
+type AsyncLossFunction func(Vector) <-chan float64
+
 // Map the parameter vector to a constrained version of itself.
 type ConstraintFunction func(Vector) Vector
 
+// A pool of goroutines that runs submitted tasks. Submit may block until the
+// pool has capacity for the task.
+type Pool interface {
+	Submit(task func())
+}
+
 // An instance of the SPSA optimization algorithm.
 // Initialize with all the parameters as object instantiation.
 type SPSA struct {
@@ -57,75 +87,1010 @@ type SPSA struct {
 	Ak, Ck GainSequence
 	Delta  PerturbationDistribution
 	C      ConstraintFunction
+
+	// Optional per-coordinate ak sequence. When set, it is used instead of Ak
+	// and each gradient component is scaled by its own gain.
+	AkVector VectorGainSequence
+
+	// Step by ak times the sign of each gradient component instead of the
+	// gradient itself (sign-SGD). This is more robust on badly scaled problems.
+	SignUpdate bool
+
+	// Optional adaptive ck rule. When set, it is used instead of Ck.
+	AdaptCk *AdaptiveCk
+
+	// Optional adaptive ak rule. When set, it is used instead of Ak.
+	AdaptAk *AdaptiveAk
+
+	// If positive, ck is clamped from below at this floor. Loss noise doesn't
+	// shrink with ck, so in long runs a tiny ck leaves a gradient estimate that
+	// is mostly noise.
+	CkFloor float64
+
+	// Draw a fresh delta every other round and reuse the negation of the
+	// previous delta in between. Note that the two-sided gradient estimate is
+	// symmetric in delta, so a pair evaluated at the same theta agrees exactly;
+	// any variance benefit comes from theta moving between the two rounds.
+	Antithetic bool
+	antithetic Vector
+
+	// If in (0, 1), each round only perturbs a random subset of roughly this
+	// fraction of the coordinates. The rest get a zero delta and a zero
+	// gradient component. This scales SPSA to very high dimensional problems.
+	PerturbFraction float64
+
+	// If positive, |delta_i| is clamped to at least EpsFloor in the gradient
+	// denominator (not in the perturbation itself). This bounds the gradient
+	// magnitude when ck is tiny, trading a little bias for numerical stability.
+	EpsFloor float64
+
+	// Apply the constraint function to the perturbed points theta +/- ck*delta
+	// before evaluating the loss, so it is never queried at infeasible points.
+	ConstrainPerturbations bool
+
+	// Optional bounds into which the perturbed points are reflected before
+	// evaluating the loss. Unlike clamping, which collapses the perturbation of
+	// a coordinate near its bound, reflection preserves the size of its
+	// excursion, so the gradient estimate is less biased near the bounds.
+	ReflectConstraint BoundedConstraints
+
+	// Optional hook run after the constraint function each round. Its return
+	// value replaces theta. Unlike a constraint, it knows the 1-based round
+	// number, so it can be round-dependent and stateful.
+	AfterUpdate func(round int, theta Vector) Vector
+
+	// Optional running statistics updated with every loss evaluation.
+	LossStats *OnlineStats
+
+	// Optional quantile tracker updated with every loss evaluation, to monitor
+	// the spread of recent losses.
+	LossQuantiles *QuantileTracker
+
+	// Optional asynchronous loss function used in place of L. The plus and
+	// minus perturbations are launched together and awaited, which reduces
+	// the latency of network-bound losses. Either L or AsyncL must be set.
+	AsyncL AsyncLossFunction
+
+	// Optional loss function of theta and one observation, optimized online by
+	// calling Feed with each observation as it arrives.
+	OnlineL OnlineLossFunction
+
+	// Memoize the losses at the perturbed points theta +/- ck * delta within a
+	// run, so that repeated identical perturbations (e.g. under antithetic or
+	// deterministic deltas) reuse them. Cache hits don't count as evaluations.
+	// Only use this with deterministic loss functions.
+	CacheEvaluations bool
+	evalCache        map[string]float64
+
+	// Optional pool on which the plus and minus perturbations are evaluated
+	// concurrently, along with all the Directions of a round. Sharing one pool
+	// bounds the concurrency of many SPSA instances. L must be safe for
+	// concurrent use when this is set. Results don't depend on the order in
+	// which the evaluations complete. The Directions are evaluated one after
+	// another when CacheEvaluations is set, and AsyncL bypasses the pool.
+	Pool Pool
+
+	// If in (0, 1], Run keeps a running (Polyak-Ruppert) average of the theta
+	// iterates over this final fraction of its rounds. See AveragedTheta.
+	PolyakFraction float64
+	avgTheta       Vector
+	avgN           int
+
+	// Optional sampler of whole perturbation directions used in place of Delta.
+	// See DirectionSampler for how the gradient is reconstructed. Antithetic,
+	// PerturbFraction, DeltaFilter and EpsFloor only apply to Delta.
+	DirectionSampler DirectionSampler
+
+	// Optional gain sequence pk that scales the perturbations at which the loss
+	// is evaluated, theta +/- pk * delta, while the gradient is still divided by
+	// ck * delta. This decouples the two: for a smooth loss the estimate is
+	// scaled by pk / ck in expectation (biased unless pk = ck), while the
+	// variance due to loss noise depends on ck instead of pk. Choosing pk < ck
+	// trades bias for a lower variance than running with ck = pk.
+	PerturbScale GainSequence
+
+	// If greater than 1, each gradient estimate averages this many independent
+	// two-sided estimates, each with its own delta and the same ck (mini-batch
+	// SPSA). This costs 2 * Directions loss evaluations per round and reduces
+	// the estimate's variance roughly like 1 / Directions.
+	Directions int
+
+	// Coordinates of theta marked true are never perturbed nor updated, so only
+	// the rest of theta is optimized. It may be shorter than theta.
+	Frozen []bool
+
+	// Optional schedule of Frozen masks, which replaces Frozen at the start of
+	// every round, e.g. to optimize a few coordinates before the rest.
+	FreezeSchedule FreezeSchedule
+
+	// Optional hook to post-process each sampled and ck-scaled delta before the
+	// loss is evaluated, e.g. zeroing frozen coordinates. Coordinates with a
+	// zero delta get a zero gradient component.
+	DeltaFilter func(delta Vector) Vector
+
+	// If positive, each round's step ak * Gk is scaled down to at most this
+	// norm before the constraint function is applied, capping the movement of
+	// theta rather than the gradient.
+	TrustRadius float64
+
+	// Optional cooling temperature schedule T_k for a simulated annealing style
+	// Metropolis acceptance of each step: a step that increases the loss by dL
+	// is undone unless a uniform draw is below exp(-dL / T_k). This costs one
+	// loss evaluation per round (two after theta is changed outside of it).
+	// The draws come from AnnealSource, or the global generator if it is nil.
+	Temperature  GainSequence
+	AnnealSource Source
+	annealTheta  Vector
+	annealLoss   float64
+
+	// If positive, a run stops when the norm of theta exceeds this limit (or
+	// isn't finite) and theta is restored to its value before that round. This
+	// applies to every way of running rounds, including RunUntil, RunBudget and
+	// Feed. RunChecked reports this as an error.
+	DivergenceLimit float64
+
+	// Scale each gradient component by the inverse square root of the running
+	// sum of its squares (AdaGrad), so theta_i -= ak / sqrt(accum_i + eps) * g_i.
+	AdaGrad     bool
+	gradSqAccum Vector
+
+	// Evaluate the loss at theta once at the end of every round, storing it in
+	// LastLoss and appending it to LossHistory. This costs one extra loss
+	// evaluation per round.
+	TrackLoss   bool
+	LastLoss    float64
+	LossHistory Vector
+	lossTheta   Vector
+
+	// Optional plateau escape: when the loss at theta hasn't improved for a
+	// number of rounds, theta is kicked in a random direction. Like TrackLoss,
+	// this evaluates the loss at theta every round.
+	Kick *PlateauKick
+
+	// The norm of the random kick applied by MaybeRestart.
+	RestartSize float64
+	restartLoss float64
+	restartSeen bool
+
+	// Optional channel receiving the full record of every gradient estimate
+	// (one per direction, so Directions per round) for offline analysis.
+	// Sends block, so it must be buffered or drained concurrently.
+	Trace chan<- RoundTrace
+
+	// If positive, Run writes a Checkpoint of the resumable state to
+	// CheckpointPath every CheckpointEvery rounds. A failed write stops the run;
+	// RunChecked and RunDetailed report the error, while Run doesn't. Validate
+	// rejects a positive CheckpointEvery without a CheckpointPath.
+	CheckpointEvery int
+	CheckpointPath  string
+
+	// The ak and ck gain values used most recently. LastAk is only set for the
+	// scalar Ak sequence.
+	LastAk, LastCk float64
+
+	// The next values of Ck and PerturbScale, if they were read ahead by
+	// peekGains. nextCk and nextPk use them before reading the sequences.
+	peekedCk, peekedPk       float64
+	hasPeekedCk, hasPeekedPk bool
+
+	// The previous gradient estimate, its cosine similarity with the one before
+	// and the raw finite difference of the last estimate.
+	lastGrad   Vector
+	gradCosine float64
+	rawDiff    float64
+
+	// Number of rounds run and loss evaluations made so far.
+	k, evals int
 }
 
 //****************** SPSA Implementation ****************
 
+// The eps added to the AdaGrad accumulator to avoid dividing by zero.
+const adaGradEps = 1e-8
+
 // A helper function to optimize a loss function using SPSA using mostly default options.
 // It uses standard ak and ck gain sequences, bernoulli +/- 1 perturbation distribution
 // and n rounds. The constraint function is optional.
 func Optimize(L LossFunction, theta0 Vector, n int, a, c float64, C ...ConstraintFunction) Vector {
-	constraint := NoConstraints
+	return optimize(L, theta0, n, a, c, Bernoulli{1}, C)
+}
+
+// A helper function like Optimize, but the bernoulli +/- 1 perturbations are drawn
+// from a private random number generator seeded by seed. The same seed always
+// produces the same result.
+func OptimizeSeeded(L LossFunction, theta0 Vector, n int, a, c float64, seed int64, C ...ConstraintFunction) Vector {
+	return optimize(L, theta0, n, a, c, NewSeededBernoulli(1, seed), C)
+}
+
+// A helper function like Optimize, but with any perturbation distribution delta
+// in place of bernoulli +/- 1.
+func OptimizeWith(L LossFunction, theta0 Vector, n int, a, c float64, delta PerturbationDistribution, C ...ConstraintFunction) Vector {
+	return optimize(L, theta0, n, a, c, delta, C)
+}
+
+// A helper function like Optimize, but with the gain sequence exponents alpha and
+// gamma exposed. alpha = 1 and gamma = 1/6 are asymptotically optimal for very long runs.
+func OptimizeTuned(L LossFunction, theta0 Vector, n int, a, c, alpha, gamma float64, C ...ConstraintFunction) Vector {
+	opts := Options{N: n, A: a, C: c, Alpha: alpha, Gamma: gamma}
 	if len(C) > 0 {
-		constraint = C[0]
+		opts.Constraint = C[0]
+	}
+	return OptimizeOptions(L, theta0, opts)
+}
+
+// A helper function like Optimize that also returns the final loss and whether
+// it is below threshold, packaging the common pattern of checking a loss
+// function in tests.
+func OptimizeChecked(L LossFunction, theta0 Vector, n int, a, c, threshold float64, C ...ConstraintFunction) (Vector, float64, bool) {
+	theta := optimize(L, theta0, n, a, c, Bernoulli{1}, C)
+	loss := L(theta)
+	return theta, loss, loss < threshold
+}
+
+func optimize(L LossFunction, theta0 Vector, n int, a, c float64, delta PerturbationDistribution, C []ConstraintFunction) Vector {
+	opts := Options{N: n, A: a, C: c, Distribution: delta}
+	if len(C) > 0 {
+		opts.Constraint = C[0]
+	}
+	return OptimizeOptions(L, theta0, opts)
+}
+
+// The tunable parameters of the Optimize helpers, by name. N, A and C are
+// required; any other field left at its zero value takes the default used by
+// Optimize.
+type Options struct {
+	N     int     // Number of rounds
+	A     float64 // Scale a of the ak gain sequence
+	C     float64 // Scale c of the ck gain sequence
+	Alpha float64 // Exponent of the ak gain sequence (default .602)
+	Gamma float64 // Exponent of the ck gain sequence (default .101)
+	ABias float64 // Bias A of the ak gain sequence (default N/10, at least 1)
+
+	// The perturbation distribution (default bernoulli +/- 1, seeded by Seed if it is non-zero)
+	Distribution PerturbationDistribution
+	Constraint   ConstraintFunction // (default NoConstraints)
+	Seed         int64
+}
+
+// The smallest default bias A of the ak gain sequence, which keeps the first
+// steps of short runs from being overly aggressive.
+const minABias = 1
+
+// The default bias A of the ak gain sequence for n rounds: 10% of n, but at least minABias.
+func aBias(n int) float64 {
+	return math.Max(float64(n)/10, minABias)
+}
+
+// A helper function to optimize a loss function using SPSA with named options.
+func OptimizeOptions(L LossFunction, theta0 Vector, opts Options) Vector {
+	if opts.Alpha == 0 {
+		opts.Alpha = .602
+	}
+	if opts.Gamma == 0 {
+		opts.Gamma = .101
+	}
+	if opts.ABias == 0 {
+		opts.ABias = aBias(opts.N)
+	}
+	if opts.Distribution == nil {
+		if opts.Seed != 0 {
+			opts.Distribution = NewSeededBernoulli(1, opts.Seed)
+		} else {
+			opts.Distribution = Bernoulli{1}
+		}
+	}
+	if opts.Constraint == nil {
+		opts.Constraint = NoConstraints
 	}
 
 	spsa := &SPSA{
 		Theta: theta0,
 		L:     L,
-		Ak:    StandardAk(a, float64(n/10), .602),
-		Ck:    StandardCk(c, .101),
-		Delta: Bernoulli{1},
-		C:     constraint,
+		Ak:    StandardAk(opts.A, opts.ABias, opts.Alpha),
+		Ck:    StandardCk(opts.C, opts.Gamma),
+		Delta: opts.Distribution,
+		C:     opts.Constraint,
 	}
 
-	return spsa.Run(n)
+	return spsa.Run(opts.N)
 }
 
 // Helper function to run many rounds of SPSA and return the current Theta value.
+// A run stopped early by divergence or a failed checkpoint write returns the
+// theta it stopped at without the error; use RunChecked to see it.
 func (spsa *SPSA) Run(rounds int) Vector {
+	spsa.run(rounds, nil)
+	return spsa.Theta
+}
+
+// Helper function to run many rounds of SPSA and return the current Theta value
+// along with the gradient estimate of the last round, before any SignUpdate or
+// AdaGrad scaling. The gradient is nil if no rounds have been run.
+func (spsa *SPSA) RunWithGradient(rounds int) (Vector, Vector) {
+	spsa.run(rounds, nil)
+	if spsa.lastGrad == nil {
+		return spsa.Theta, nil
+	}
+	return spsa.Theta, spsa.lastGrad.Copy()
+}
+
+// Run many rounds of SPSA, stopping early with an error if theta diverges.
+// If each is not nil, it is called after every completed round, and the run
+// stops early with its error if it returns one.
+func (spsa *SPSA) run(rounds int, each func() error) error {
+	start := spsa.begin(rounds)
 	for i := 0; i < rounds; i++ {
-		spsa.round()
+		if err := spsa.step(i >= start); err != nil {
+			return err
+		}
+		if each != nil {
+			if err := each(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Start a run of rounds rounds: reset the iterate average and the evaluation
+// cache, and return the first round whose iterate is averaged.
+func (spsa *SPSA) begin(rounds int) int {
+	spsa.avgTheta, spsa.avgN = nil, 0
+	spsa.evalCache = nil
+	return rounds - int(spsa.PolyakFraction*float64(rounds))
+}
+
+// Run one round of a run. If theta diverges beyond DivergenceLimit, it is
+// restored and an error is returned. Otherwise theta is folded into the
+// iterate average if average is set and a checkpoint is written if one is due.
+func (spsa *SPSA) step(average bool) error {
+	last := spsa.Theta
+	spsa.round()
+	if spsa.DivergenceLimit > 0 && !(spsa.Theta.Norm() <= spsa.DivergenceLimit) {
+		spsa.Theta = last
+		return fmt.Errorf("spsa: theta diverged beyond norm %v in round %d", spsa.DivergenceLimit, spsa.k)
+	}
+	if spsa.PolyakFraction > 0 && average {
+		spsa.average()
+	}
+	if spsa.CheckpointEvery > 0 && spsa.k%spsa.CheckpointEvery == 0 {
+		return spsa.WriteCheckpoint(spsa.CheckpointPath)
+	}
+	return nil
+}
+
+// Run whole rounds of SPSA while the total number of loss evaluations stays within
+// maxEvals and return the current Theta value. This is useful for comparisons
+// with optimizers measured by function evaluations. A round is only started if
+// its worst-case cost fits, so options with occasional extra evaluations (Kick,
+// Temperature) may leave part of the budget unused. Like Run, it stops early if
+// theta diverges or a checkpoint write fails, and PolyakFraction applies to the
+// number of rounds the budget allows at their worst-case cost.
+func (spsa *SPSA) RunBudget(maxEvals int) Vector {
+	start := spsa.begin((maxEvals - spsa.evals) / spsa.maxRoundEvals())
+	for i := 0; spsa.evals+spsa.maxRoundEvals() <= maxEvals; i++ {
+		if err := spsa.step(i >= start); err != nil {
+			break
+		}
 	}
 	return spsa.Theta
 }
 
+// The most loss evaluations one round can make with the enabled options.
+func (spsa *SPSA) maxRoundEvals() int {
+	n := 2
+	if spsa.Directions > 1 {
+		n *= spsa.Directions
+	}
+	if spsa.Temperature != nil {
+		n += 2
+	}
+	if spsa.tracksLoss() {
+		n++
+	}
+	if spsa.Kick != nil {
+		n++
+	}
+	return n
+}
+
+// Number of loss evaluations made so far.
+func (spsa *SPSA) Evaluations() int {
+	return spsa.evals
+}
+
+// Fold the current Theta into the running average of iterates.
+func (spsa *SPSA) average() {
+	spsa.avgN++
+	if spsa.avgTheta == nil {
+		spsa.avgTheta = spsa.Theta.Copy()
+		return
+	}
+	for i, v := range spsa.Theta {
+		spsa.avgTheta[i] += (v - spsa.avgTheta[i]) / float64(spsa.avgN)
+	}
+}
+
+// The Polyak-Ruppert average of the theta iterates over the final PolyakFraction
+// of the last Run. It has lower asymptotic variance than the last iterate.
+// If no iterates were averaged, it is a copy of Theta.
+func (spsa *SPSA) AveragedTheta() Vector {
+	if spsa.avgTheta == nil {
+		return spsa.Theta.Copy()
+	}
+	return spsa.avgTheta.Copy()
+}
+
+// Helper function to validate the SPSA instance and then run many rounds of SPSA.
+// It returns the current Theta value or the validation error. If theta diverges,
+// it returns the last theta within the DivergenceLimit and an error.
+func (spsa *SPSA) RunChecked(rounds int) (Vector, error) {
+	if err := spsa.Validate(); err != nil {
+		return nil, err
+	}
+	err := spsa.run(rounds, nil)
+	return spsa.Theta, err
+}
+
+// Check that all the required fields of the SPSA instance are set, that
+// Theta is non-empty and that no conflicting options are set.
+func (spsa *SPSA) Validate() error {
+	switch {
+	case len(spsa.Theta) == 0:
+		return errors.New("spsa: Theta is empty")
+	case spsa.L == nil && spsa.AsyncL == nil:
+		return errors.New("spsa: loss function L is not set")
+	case spsa.Ak == nil && spsa.AkVector == nil && spsa.AdaptAk == nil:
+		return errors.New("spsa: gain sequence Ak is not set")
+	case spsa.AkVector != nil && spsa.AdaptAk != nil:
+		return errors.New("spsa: AkVector and AdaptAk are both set")
+	case spsa.Ck == nil && spsa.AdaptCk == nil:
+		return errors.New("spsa: gain sequence Ck is not set")
+	case spsa.Delta == nil && spsa.DirectionSampler == nil:
+		return errors.New("spsa: perturbation distribution Delta is not set")
+	case spsa.C == nil:
+		return errors.New("spsa: constraint function C is not set")
+	case spsa.CheckpointEvery > 0 && spsa.CheckpointPath == "":
+		return errors.New("spsa: CheckpointEvery is set without a CheckpointPath")
+	}
+	return nil
+}
+
 // Run one round of SPSA.
 func (spsa *SPSA) round() {
-	// Estimate gradient and scale it by ak
-	Gk := spsa.estimateGradient().Scale(<-spsa.Ak)
+	if spsa.FreezeSchedule != nil {
+		spsa.Frozen = spsa.FreezeSchedule.mask(spsa.k)
+	}
+
+	// Estimate gradient
+	Gk := spsa.estimateGradient()
+	for i := range Gk {
+		if spsa.frozen(i) {
+			Gk[i] = 0
+		}
+	}
+	if spsa.lastGrad != nil {
+		spsa.gradCosine = Gk.Normalize().Dot(spsa.lastGrad.Normalize())
+	}
+	spsa.lastGrad = Gk.Copy()
+	if spsa.SignUpdate {
+		Gk = Gk.Sign()
+	}
+	if spsa.AdaGrad {
+		Gk = spsa.adaGrad(Gk)
+	}
 
 	// Adjust theta via SA
-	spsa.Theta = spsa.Theta.Subtract(Gk)
+	prev := spsa.Theta
+	if spsa.AkVector != nil {
+		ak := <-spsa.AkVector
+		for i := range Gk {
+			Gk[i] *= ak[i]
+		}
+		spsa.Theta = spsa.Theta.AddScaled(Gk, -spsa.trustScale(Gk.Norm()))
+	} else {
+		spsa.LastAk = spsa.nextAk()
+		s := spsa.LastAk * spsa.trustScale(spsa.LastAk*Gk.Norm())
+		spsa.Theta = spsa.Theta.AddScaled(Gk, -s)
+	}
 
 	// Correct any constraints
 	spsa.Theta = spsa.C(spsa.Theta)
+
+	if spsa.Temperature != nil {
+		spsa.metropolis(prev)
+	}
+
+	spsa.k++
+	if spsa.AfterUpdate != nil {
+		spsa.Theta = spsa.AfterUpdate(spsa.k, spsa.Theta)
+	}
+
+	if spsa.tracksLoss() {
+		// Reuse the Metropolis loss unless AfterUpdate changed theta
+		loss := spsa.annealLoss
+		if !spsa.Theta.equal(spsa.annealTheta) {
+			loss = spsa.evaluate(spsa.Theta)
+		}
+		spsa.setLastLoss(loss)
+		if spsa.Kick != nil && spsa.Kick.stalled(spsa.LastLoss) {
+			spsa.Theta = spsa.C(spsa.Kick.kick(spsa.Theta, spsa.frozen))
+			spsa.setLastLoss(spsa.evaluate(spsa.Theta))
+		}
+		if spsa.AdaptAk != nil {
+			spsa.AdaptAk.update(spsa.LastLoss)
+		}
+	}
+	if spsa.TrackLoss {
+		spsa.LossHistory = append(spsa.LossHistory, spsa.LastLoss)
+	}
+}
+
+// Accept the tentative theta if it doesn't increase the loss, and otherwise with
+// probability exp(-dL / T) for the next temperature T. A rejected theta is
+// replaced by prev.
+func (spsa *SPSA) metropolis(prev Vector) {
+	T := <-spsa.Temperature
+
+	fprev := spsa.annealLoss
+	if !prev.equal(spsa.annealTheta) {
+		fprev = spsa.evaluate(prev)
+	}
+	f := spsa.evaluate(spsa.Theta)
+	var src Source = globalSource{}
+	if spsa.AnnealSource != nil {
+		src = spsa.AnnealSource
+	}
+	if d := f - fprev; d > 0 && !(src.Float64() < math.Exp(-d/T)) {
+		spsa.Theta, f = prev, fprev
+	}
+	spsa.annealTheta, spsa.annealLoss = spsa.Theta.Copy(), f
+}
+
+// Cosine similarity between the gradient estimates of the last two rounds, or 0
+// before the second round. Values near 1 indicate steady descent, while low or
+// negative values indicate oscillation, often because the step size is too large.
+func (spsa *SPSA) LastGradientCosine() float64 {
+	return spsa.gradCosine
+}
+
+// Whether the loss at theta is evaluated at the end of every round.
+func (spsa *SPSA) tracksLoss() bool {
+	return spsa.TrackLoss || spsa.Kick != nil || spsa.AdaptAk != nil
+}
+
+// The factor to scale a step of norm step by so that it is no longer than TrustRadius.
+func (spsa *SPSA) trustScale(step float64) float64 {
+	if spsa.TrustRadius > 0 && step > spsa.TrustRadius {
+		return spsa.TrustRadius / step
+	}
+	return 1
+}
+
+// A schedule of Frozen masks keyed by round thresholds. The mask with the
+// largest threshold k at most the number of completed rounds applies, so
+// FreezeSchedule{0: {false, true}, 50: nil} freezes coordinate 1 for the
+// first 50 rounds. Before the smallest threshold nothing is frozen.
+type FreezeSchedule map[int][]bool
+
+// The mask for a round after k completed rounds.
+func (fs FreezeSchedule) mask(k int) []bool {
+	var mask []bool
+	best := -1
+	for threshold, m := range fs {
+		if threshold <= k && threshold > best {
+			mask, best = m, threshold
+		}
+	}
+	return mask
+}
+
+// Whether coordinate i of theta is frozen.
+func (spsa *SPSA) frozen(i int) bool {
+	return i < len(spsa.Frozen) && spsa.Frozen[i]
+}
+
+// Accumulate the squared gradient components and scale each component of the
+// gradient by the inverse square root of its accumulator.
+func (spsa *SPSA) adaGrad(grad Vector) Vector {
+	if spsa.gradSqAccum == nil {
+		spsa.gradSqAccum = make(Vector, len(grad))
+	}
+	scaled := make(Vector, len(grad))
+	for i, g := range grad {
+		spsa.gradSqAccum[i] += g * g
+		scaled[i] = g / math.Sqrt(spsa.gradSqAccum[i]+adaGradEps)
+	}
+	return scaled
+}
+
+// Polish the current Theta with a golden-section line search along the
+// negative of a fresh gradient estimate. Step lengths t in [0, 1] are searched,
+// i.e. up to one full unscaled gradient step, and every candidate is passed
+// through the constraint function. Theta is only replaced if the loss improves.
+// This consumes one ck value and performs steps + 6 loss evaluations.
+func (spsa *SPSA) Polish(steps int) Vector {
+	grad := spsa.estimateGradient()
+	at := func(t float64) Vector {
+		return spsa.C(spsa.Theta.Subtract(grad.Scale(t)))
+	}
+
+	invphi := (math.Sqrt(5) - 1) / 2
+	lo, hi := 0.0, 1.0
+	x1, x2 := hi-invphi*(hi-lo), lo+invphi*(hi-lo)
+	f1, f2 := spsa.evaluate(at(x1)), spsa.evaluate(at(x2))
+	for i := 0; i < steps; i++ {
+		if f1 < f2 {
+			hi, x2, f2 = x2, x1, f1
+			x1 = hi - invphi*(hi-lo)
+			f1 = spsa.evaluate(at(x1))
+		} else {
+			lo, x1, f1 = x1, x2, f2
+			x2 = lo + invphi*(hi-lo)
+			f2 = spsa.evaluate(at(x2))
+		}
+	}
+
+	if best := at((lo + hi) / 2); spsa.evaluate(best) <= spsa.evaluate(spsa.Theta) {
+		spsa.Theta = best
+	}
+	return spsa.Theta
+}
+
+// Evaluate the loss function at theta and record it in any enabled statistics.
+func (spsa *SPSA) evaluate(theta Vector) float64 {
+	var loss float64
+	if spsa.AsyncL != nil {
+		loss = <-spsa.AsyncL(theta)
+	} else {
+		loss = spsa.L(theta)
+	}
+	spsa.record(loss)
+	return loss
+}
+
+// Evaluate the loss function at a and b, using the cache if CacheEvaluations is set.
+func (spsa *SPSA) evaluatePair(a, b Vector) (fa, fb float64) {
+	if spsa.CacheEvaluations {
+		return spsa.evaluatePairCached(a, b)
+	}
+	return spsa.evaluatePairUncached(a, b)
+}
+
+// Evaluate the loss function at a and b, reusing the cached losses of points
+// evaluated before in this run.
+func (spsa *SPSA) evaluatePairCached(a, b Vector) (fa, fb float64) {
+	if spsa.evalCache == nil {
+		spsa.evalCache = make(map[string]float64)
+	}
+	ka, kb := fingerprint(a), fingerprint(b)
+	fa, okA := spsa.evalCache[ka]
+	fb, okB := spsa.evalCache[kb]
+
+	switch {
+	case okA && okB:
+	case okA:
+		fb = spsa.evaluate(b)
+	case okB:
+		fa = spsa.evaluate(a)
+	default:
+		fa, fb = spsa.evaluatePairUncached(a, b)
+	}
+
+	spsa.evalCache[ka], spsa.evalCache[kb] = fa, fb
+	return fa, fb
+}
+
+// Evaluate the loss function at a and b, concurrently on the Pool if one is set.
+func (spsa *SPSA) evaluatePairUncached(a, b Vector) (fa, fb float64) {
+	if spsa.AsyncL != nil {
+		return spsa.evaluatePairAsync(a, b)
+	}
+	if spsa.Pool == nil {
+		return spsa.evaluate(a), spsa.evaluate(b)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	spsa.Pool.Submit(func() {
+		defer wg.Done()
+		fa = spsa.L(a)
+	})
+	spsa.Pool.Submit(func() {
+		defer wg.Done()
+		fb = spsa.L(b)
+	})
+	wg.Wait()
+
+	spsa.record(fa)
+	spsa.record(fb)
+	return fa, fb
+}
+
+// Launch the asynchronous loss at a and b together and await both, in whichever
+// order they arrive.
+func (spsa *SPSA) evaluatePairAsync(a, b Vector) (fa, fb float64) {
+	ca, cb := spsa.AsyncL(a), spsa.AsyncL(b)
+	for ca != nil || cb != nil {
+		select {
+		case fa = <-ca:
+			ca = nil
+		case fb = <-cb:
+			cb = nil
+		}
+	}
+
+	spsa.record(fa)
+	spsa.record(fb)
+	return fa, fb
+}
+
+// Record a loss evaluation in the evaluation count and any enabled statistics.
+func (spsa *SPSA) record(loss float64) {
+	spsa.evals++
+	if spsa.LossStats != nil {
+		spsa.LossStats.Add(loss)
+	}
+	if spsa.LossQuantiles != nil {
+		spsa.LossQuantiles.Add(loss)
+	}
+}
+
+// Mean of all the loss evaluations so far. Requires LossStats to be set.
+func (spsa *SPSA) LossMean() float64 {
+	return spsa.LossStats.Mean()
+}
+
+// Variance of all the loss evaluations so far. Requires LossStats to be set.
+func (spsa *SPSA) LossVar() float64 {
+	return spsa.LossStats.Var()
+}
+
+// Estimate the gradient of the loss at the current Theta without updating it,
+// for users who manage their own update loop. It consumes one ck value and
+// performs two loss evaluations. Each component is divided by 2 * ck * delta_i,
+// so the estimate is in units of loss per unit of theta regardless of ck.
+func (spsa *SPSA) EstimateGradient() Vector {
+	return spsa.estimateGradient()
+}
+
+// The raw finite difference L(theta + ck*delta) - L(theta - ck*delta) of the
+// last gradient estimate, before dividing by 2 * ck * delta. Its spread is
+// useful for estimating the loss noise. With several Directions, it is the
+// difference along the last one.
+func (spsa *SPSA) RawDifference() float64 {
+	return spsa.rawDiff
 }
 
 // Estimate the gradient in one round of spsa
 func (spsa *SPSA) estimateGradient() Vector {
-	n := len(spsa.Theta)
+	// ck is read exactly once per estimate: the same ck must scale both the plus
+	// and minus perturbations and, through delta, the gradient denominator, or
+	// the estimate is biased.
+	ck := spsa.nextCk()
+	pk := spsa.nextPk(ck)
 
-	// Get delta vector
-	delta := SampleN(n, spsa.Delta).Scale(<-spsa.Ck)
+	// Average the estimates along several independent directions
+	var grad Vector
+	if spsa.Directions > 1 && spsa.Pool != nil && spsa.AsyncL == nil && !spsa.CacheEvaluations {
+		grad = spsa.estimateDirectionsParallel(ck, pk)
+	} else {
+		grad = spsa.estimateDirection(ck, pk)
+		if spsa.Directions > 1 {
+			for j := 1; j < spsa.Directions; j++ {
+				grad = grad.Add(spsa.estimateDirection(ck, pk))
+			}
+			grad = grad.Scale(1 / float64(spsa.Directions))
+		}
+	}
+
+	if spsa.AdaptCk != nil {
+		spsa.AdaptCk.update(grad)
+	}
 
-	// Evaluate theta + ck * delta
-	tpos := spsa.Theta.Add(delta)
-	fpos := spsa.L(tpos)
+	return grad
+}
 
-	// Evaluate theta - ck * delta
-	tneg := spsa.Theta.Subtract(delta)
-	fneg := spsa.L(tneg)
+// Estimate the gradient along one simultaneous perturbation direction scaled by
+// ck, evaluating the loss at the perturbation scaled by pk.
+func (spsa *SPSA) estimateDirection(ck, pk float64) Vector {
+	p := spsa.perturb(ck, pk)
+	fpos, fneg := spsa.evaluatePair(p.tpos, p.tneg)
+	return spsa.gradient(p, ck, fpos, fneg)
+}
 
-	// Calculate estimated gradient
-	grad := make([]float64, n)
-	for i, d := range delta {
-		grad[i] = (fpos - fneg) / (2 * d)
+// Estimate and average the gradient along Directions perturbations, evaluating
+// all of them concurrently on the Pool. The perturbations are sampled and the
+// estimates accumulated in index order, regardless of the order in which the
+// evaluations complete, so the result is bit-for-bit the same as estimating
+// them one after another.
+func (spsa *SPSA) estimateDirectionsParallel(ck, pk float64) Vector {
+	ps := make([]perturbation, spsa.Directions)
+	for j := range ps {
+		ps[j] = spsa.perturb(ck, pk)
 	}
 
+	losses := make([]float64, 2*len(ps))
+	var wg sync.WaitGroup
+	for j, p := range ps {
+		for side, theta := range []Vector{p.tpos, p.tneg} {
+			i, theta := 2*j+side, theta
+			wg.Add(1)
+			spsa.Pool.Submit(func() {
+				defer wg.Done()
+				losses[i] = spsa.L(theta)
+			})
+		}
+	}
+	wg.Wait()
+
+	var grad Vector
+	for j, p := range ps {
+		fpos, fneg := losses[2*j], losses[2*j+1]
+		spsa.record(fpos)
+		spsa.record(fneg)
+		if g := spsa.gradient(p, ck, fpos, fneg); grad == nil {
+			grad = g
+		} else {
+			grad = grad.Add(g)
+		}
+	}
+	return grad.Scale(1 / float64(len(ps)))
+}
+
+// A perturbation ready to be evaluated. For a DirectionSampler, delta is the
+// unscaled direction; otherwise it is the sampled delta scaled by ck.
+type perturbation struct {
+	delta, tpos, tneg Vector
+	sampled           bool
+}
+
+// Sample a perturbation and the points theta +/- pk * delta at which to evaluate
+// the loss, where pk is ck unless PerturbScale is set.
+func (spsa *SPSA) perturb(ck, pk float64) (p perturbation) {
+	if spsa.DirectionSampler != nil {
+		d := spsa.DirectionSampler.SampleDirection(len(spsa.Theta))
+		for i := range d {
+			if spsa.frozen(i) {
+				d[i] = 0
+			}
+		}
+		p = perturbation{d, spsa.Theta.AddScaled(d, pk), spsa.Theta.AddScaled(d, -pk), true}
+	} else {
+		delta := spsa.sampleDelta().Scale(ck)
+		if spsa.DeltaFilter != nil {
+			delta = spsa.DeltaFilter(delta)
+		}
+		p = perturbation{delta, spsa.Theta.AddScaled(delta, pk/ck), spsa.Theta.AddScaled(delta, -pk/ck), false}
+	}
+
+	if spsa.ConstrainPerturbations {
+		p.tpos, p.tneg = spsa.C(p.tpos), spsa.C(p.tneg)
+	}
+	if spsa.ReflectConstraint != nil {
+		p.tpos, p.tneg = spsa.ReflectConstraint.Reflect(p.tpos), spsa.ReflectConstraint.Reflect(p.tneg)
+	}
+	return p
+}
+
+// Calculate the gradient estimate from the losses at a perturbation, recording
+// its raw difference and sending it on Trace.
+func (spsa *SPSA) gradient(p perturbation, ck, fpos, fneg float64) Vector {
+	spsa.rawDiff = fpos - fneg
+	grad := spsa.gradientOf(p, ck, fpos, fneg)
+
+	delta := p.delta
+	if p.sampled {
+		delta = delta.Scale(ck)
+	}
+	spsa.trace(delta, grad, fpos, fneg)
+	return grad
+}
+
+// Calculate the gradient estimate from the losses at a perturbation. A sampled
+// direction is projected onto; otherwise each component is divided by its delta.
+func (spsa *SPSA) gradientOf(p perturbation, ck, fpos, fneg float64) Vector {
+	if p.sampled {
+		return p.delta.Scale((fpos - fneg) / (2 * ck))
+	}
+
+	grad := make(Vector, len(p.delta))
+	for i, d := range p.delta {
+		if d != 0 {
+			if math.Abs(d) < spsa.EpsFloor {
+				d = math.Copysign(spsa.EpsFloor, d)
+			}
+			grad[i] = (fpos - fneg) / (2 * d)
+		}
+	}
 	return grad
 }
 
+// The record of one gradient estimate sent on SPSA.Trace.
+type RoundTrace struct {
+	Round        int     // 1-based round of the estimate
+	Ck           float64 // The ck gain of the estimate
+	Delta        Vector  // The perturbation, i.e. the sampled delta scaled by ck
+	Fpos, Fneg   float64 // The losses at the plus and minus perturbations
+	GradEstimate Vector  // The gradient estimate along this perturbation
+}
+
+// Send the record of one gradient estimate on Trace, if it is set.
+func (spsa *SPSA) trace(delta, grad Vector, fpos, fneg float64) {
+	if spsa.Trace != nil {
+		spsa.Trace <- RoundTrace{
+			Round:        spsa.k + 1,
+			Ck:           spsa.LastCk,
+			Delta:        delta.Copy(),
+			Fpos:         fpos,
+			Fneg:         fneg,
+			GradEstimate: grad.Copy(),
+		}
+	}
+}
+
+// Sample an unscaled delta vector, or reuse the negated previous one in antithetic mode.
+func (spsa *SPSA) sampleDelta() Vector {
+	if spsa.Antithetic && spsa.antithetic != nil {
+		delta := spsa.antithetic
+		spsa.antithetic = nil
+		return delta
+	}
+
+	delta := SampleN(len(spsa.Theta), spsa.Delta)
+	if spsa.PerturbFraction > 0 && spsa.PerturbFraction < 1 {
+		for i := range delta {
+			if rand.Float64() >= spsa.PerturbFraction {
+				delta[i] = 0
+			}
+		}
+	}
+	for i := range delta {
+		if spsa.frozen(i) {
+			delta[i] = 0
+		}
+	}
+	if spsa.Antithetic {
+		spsa.antithetic = delta.Scale(-1)
+	}
+	return delta
+}
+
+// Get the next ak value, from the adaptive rule if one is set.
+func (spsa *SPSA) nextAk() float64 {
+	if spsa.AdaptAk != nil {
+		return spsa.AdaptAk.A
+	}
+	return <-spsa.Ak
+}
+
+// Get the next ck value, from the adaptive rule if one is set, clamped at CkFloor.
+func (spsa *SPSA) nextCk() float64 {
+	if spsa.AdaptCk != nil {
+		spsa.LastCk = spsa.AdaptCk.C
+	} else if spsa.hasPeekedCk {
+		spsa.LastCk, spsa.hasPeekedCk = spsa.peekedCk, false
+	} else {
+		spsa.LastCk = <-spsa.Ck
+	}
+	if spsa.LastCk < spsa.CkFloor {
+		spsa.LastCk = spsa.CkFloor
+	}
+	return spsa.LastCk
+}
+
+// Get the next pk value from PerturbScale, or ck if it isn't set.
+func (spsa *SPSA) nextPk(ck float64) float64 {
+	switch {
+	case spsa.PerturbScale == nil:
+		return ck
+	case spsa.hasPeekedPk:
+		spsa.hasPeekedPk = false
+		return spsa.peekedPk
+	}
+	return <-spsa.PerturbScale
+}
+
 //********** Constrain function helpers ***********
 
 // A ConstraintFunction that is just the identity mapper
@@ -139,14 +1104,149 @@ type Bounds struct {
 	Lower, Upper float64
 }
 
+// The midpoint of the bounds, or the finite bound if the other is infinite,
+// or 0 if both are.
+func (b Bounds) midpoint() float64 {
+	switch lo, hi := math.IsInf(b.Lower, -1), math.IsInf(b.Upper, 1); {
+	case lo && hi:
+		return 0
+	case lo:
+		return b.Upper
+	case hi:
+		return b.Lower
+	}
+	return (b.Lower + b.Upper) / 2
+}
+
+// Reflect x into the bounds, folding it back at each bound it passes. NaN maps
+// to the midpoint.
+func (b Bounds) reflect(x float64) float64 {
+	w := b.Upper - b.Lower
+	switch {
+	case math.IsNaN(x):
+		return b.midpoint()
+	case x >= b.Lower && x <= b.Upper:
+		return x
+	case w == 0:
+		return b.Lower
+	case math.IsInf(w, 1) && x < b.Lower:
+		return 2*b.Lower - x
+	case math.IsInf(w, 1):
+		return 2*b.Upper - x
+	}
+
+	y := math.Mod(x-b.Lower, 2*w)
+	if y < 0 {
+		y += 2 * w
+	}
+	if y > w {
+		y = 2*w - y
+	}
+	return b.Lower + y
+}
+
 // An array of bounds on an array of variables. This object's Constrain function
 // can be used as a ConstraintFunction for SPSA.
 type BoundedConstraints []Bounds
 
+// Check that the bounds cover a parameter vector of dimension dim and that
+// each lower bound is no greater than its upper bound.
+func (bc BoundedConstraints) Validate(dim int) error {
+	if len(bc) != dim {
+		return fmt.Errorf("spsa: %d bounds given for a parameter vector of dimension %d", len(bc), dim)
+	}
+	for i, b := range bc {
+		if b.Lower > b.Upper {
+			return fmt.Errorf("spsa: bounds %d have lower %v greater than upper %v", i, b.Lower, b.Upper)
+		}
+	}
+	return nil
+}
+
 // Constrain theta by mapping each value into its bounded domain. (in place)
+// NaN values map to the midpoint of their bounds, so that one bad round
+// doesn't poison theta. It panics if the number of bounds doesn't match the
+// dimension of theta.
 func (bc BoundedConstraints) Constrain(theta Vector) Vector {
+	if len(bc) != len(theta) {
+		panic(fmt.Sprintf("spsa: %d bounds given for a parameter vector of dimension %d", len(bc), len(theta)))
+	}
+	for i, t := range theta {
+		if math.IsNaN(t) {
+			theta[i] = bc[i].midpoint()
+		} else {
+			theta[i] = math.Min(math.Max(t, bc[i].Lower), bc[i].Upper)
+		}
+	}
+	return theta
+}
+
+// Constrain theta by reflecting each value into its bounded domain, so that a
+// value just past a bound lands as far inside it. (in place) It panics if the
+// number of bounds doesn't match the dimension of theta.
+func (bc BoundedConstraints) Reflect(theta Vector) Vector {
+	if len(bc) != len(theta) {
+		panic(fmt.Sprintf("spsa: %d bounds given for a parameter vector of dimension %d", len(bc), len(theta)))
+	}
+	for i, t := range theta {
+		theta[i] = bc[i].reflect(t)
+	}
+	return theta
+}
+
+// An array of bounds in log-space on an array of positive variables, for
+// parameters spanning many orders of magnitude. This object's Constrain function
+// can be used as a ConstraintFunction for SPSA.
+type LogBoundedConstraints []Bounds
+
+// Constrain theta by clamping the log of each value into its bounded domain and
+// exponentiating back. (in place) Non-positive values map to the lower bound
+// and NaN values to the midpoint of the bounds in log-space. It panics if the
+// number of bounds doesn't match the dimension of theta.
+func (lbc LogBoundedConstraints) Constrain(theta Vector) Vector {
+	if len(lbc) != len(theta) {
+		panic(fmt.Sprintf("spsa: %d bounds given for a parameter vector of dimension %d", len(lbc), len(theta)))
+	}
 	for i, t := range theta {
-		theta[i] = math.Min(math.Max(t, bc[i].Lower), bc[i].Upper)
+		if math.IsNaN(t) {
+			theta[i] = math.Exp(lbc[i].midpoint())
+		} else if t <= 0 {
+			theta[i] = math.Exp(lbc[i].Lower)
+		} else {
+			theta[i] = math.Exp(math.Min(math.Max(math.Log(t), lbc[i].Lower), lbc[i].Upper))
+		}
+	}
+	return theta
+}
+
+// Block sizes of consecutive one-hot encoded categorical parameters. This object's
+// Constrain function can be used as a ConstraintFunction for SPSA.
+type OneHotConstraint []int
+
+// Constrain theta by setting the largest coordinate of each block to 1 and the rest
+// to 0. (in place) It panics if a block is empty or if the block sizes don't add
+// up to the dimension of theta.
+func (oh OneHotConstraint) Constrain(theta Vector) Vector {
+	start := 0
+	for i, size := range oh {
+		if size <= 0 {
+			panic(fmt.Sprintf("spsa: one-hot block %d has size %d", i, size))
+		}
+		start += size
+	}
+	if start != len(theta) {
+		panic(fmt.Sprintf("spsa: one-hot blocks cover %d coordinates of a parameter vector of dimension %d", start, len(theta)))
+	}
+
+	start = 0
+	for _, size := range oh {
+		block := theta[start : start+size]
+		_, hot := block.Max()
+		for i := range block {
+			block[i] = 0
+		}
+		block[hot] = 1
+		start += size
 	}
 	return theta
 }
@@ -168,6 +1268,35 @@ func StandardAk(a, A, alpha float64) GainSequence {
 	return GainSequence(c)
 }
 
+// Create an infinite iterator of a_k gain values that holds the first standard
+// value a / (1 + A) ^ alpha for burnIn rounds to make early progress, and then
+// decays like StandardAk from there, delayed by the burn-in.
+func BurnInAk(a, A, alpha float64, burnIn int) GainSequence {
+	c := make(chan float64)
+	go func() {
+		for k := 1; k < burnIn; k++ {
+			c <- a / math.Pow(1+A, alpha)
+		}
+		for v := range StandardAk(a, A, alpha) {
+			c <- v
+		}
+	}()
+	return GainSequence(c)
+}
+
+// Create an infinite iterator of per-coordinate a_k gain vectors in standard form,
+// where coordinate i follows a_k = a[i] / (k + 1 + A) ^ alpha. This suits
+// anisotropic problems where a single a is suboptimal.
+func StandardAkVector(a Vector, A, alpha float64) VectorGainSequence {
+	c := make(chan Vector)
+	go func() {
+		for k := 1; true; k++ {
+			c <- a.Scale(1 / math.Pow(float64(k)+A, alpha))
+		}
+	}()
+	return VectorGainSequence(c)
+}
+
 // Create an infinite iterator of c_k gain values in standard form.
 // Standard form is c_k = c / (k + 1) ^ gamma
 // Semiautomatic tuning says that c = sqrt(Var(L(x))) where L is the loss function
@@ -177,8 +1306,139 @@ func StandardCk(c, gamma float64) GainSequence {
 	return StandardAk(c, 0, gamma)
 }
 
+// Create an infinite iterator of gain values read from a precomputed slice.
+// The values are emitted in order and once the slice is exhausted the last
+// value is held forever. This gives complete control over the schedule.
+// values must not be empty.
+func SliceGain(values []float64) GainSequence {
+	if len(values) == 0 {
+		panic("spsa: SliceGain requires at least one value")
+	}
+	values = Vector(values).Copy()
+
+	c := make(chan float64)
+	go func() {
+		for _, v := range values {
+			c <- v
+		}
+		last := values[len(values)-1]
+		for {
+			c <- last
+		}
+	}()
+	return GainSequence(c)
+}
+
+// An adaptive c_k rule driven by the quality of the gradient estimates.
+// Successive estimates are compared by ||g_k - g_k-1|| / (||g_k|| + ||g_k-1||),
+// which is 0 when they agree and 1 when they are opposite. Above Tolerance, C is
+// grown by Factor (> 1), otherwise it is shrunk by Factor. C stays within [Min, Max].
+type AdaptiveCk struct {
+	C, Min, Max       float64
+	Factor, Tolerance float64
+
+	last Vector
+}
+
+// Update C with a new gradient estimate.
+func (ac *AdaptiveCk) update(grad Vector) {
+	if ac.last != nil {
+		if denom := grad.Norm() + ac.last.Norm(); denom > 0 {
+			if grad.Subtract(ac.last).Norm()/denom > ac.Tolerance {
+				ac.C *= ac.Factor
+			} else {
+				ac.C /= ac.Factor
+			}
+		}
+		ac.C = math.Min(math.Max(ac.C, ac.Min), ac.Max)
+	}
+	ac.last = grad
+}
+
+// An adaptive a_k rule that targets the fraction of rounds that reduce the loss,
+// like the acceptance rate tuning of MCMC samplers. On a smooth loss small steps
+// almost always reduce it while large steps overshoot, so after every round A is
+// multiplied by Factor^(ratio - Target) for Factor > 1, where ratio is the fraction
+// of the last Window rounds that reduced the loss. A grows while the ratio is above
+// Target and shrinks while it is below, so in the long run the ratio averages
+// Target. The overshooting rounds can compound on stiff losses, so a Target
+// above 1/2 and a Factor near 1 are safest. Like TrackLoss, this evaluates the
+// loss at theta every round.
+type AdaptiveAk struct {
+	A, Target, Factor float64
+	Window            int
+
+	improved []bool
+	next     int
+	full     bool
+	last     float64
+	seen     bool
+}
+
+// The fraction of the rounds in the window that reduced the loss, or NaN
+// before the first comparison.
+func (aa *AdaptiveAk) Ratio() float64 {
+	n := aa.next
+	if aa.full {
+		n = len(aa.improved)
+	}
+	var count int
+	for _, improved := range aa.improved[:n] {
+		if improved {
+			count++
+		}
+	}
+	return float64(count) / float64(n)
+}
+
+// Update A with the loss at theta after a round. A only changes once the
+// window is full.
+func (aa *AdaptiveAk) update(loss float64) {
+	if aa.Window < 1 {
+		aa.Window = 1
+	}
+	if len(aa.improved) != aa.Window {
+		aa.improved, aa.next, aa.full = make([]bool, aa.Window), 0, false
+	}
+
+	if aa.seen {
+		aa.improved[aa.next] = loss < aa.last
+		aa.next = (aa.next + 1) % aa.Window
+		if aa.next == 0 {
+			aa.full = true
+		}
+	}
+	aa.last, aa.seen = loss, true
+
+	if aa.full {
+		aa.A *= math.Pow(aa.Factor, aa.Ratio()-aa.Target)
+	}
+}
+
 //********** Perturbation Distribution *************
 
+// The global math/rand generator as a Source.
+type globalSource struct{}
+
+func (globalSource) Float64() float64 {
+	return rand.Float64()
+}
+
+// Bind a distribution to a random number Source, so that its samples are drawn
+// from src instead of the global generator.
+func WithSource(d SourcedDistribution, src Source) PerturbationDistribution {
+	return sourced{d, src}
+}
+
+type sourced struct {
+	d   SourcedDistribution
+	src Source
+}
+
+func (s sourced) Sample() float64 {
+	return s.d.SampleFrom(s.src)
+}
+
 func SampleN(n int, d PerturbationDistribution) Vector {
 	a := make([]float64, n)
 	for i := 0; i < n; i++ {
@@ -187,19 +1447,140 @@ func SampleN(n int, d PerturbationDistribution) Vector {
 	return a
 }
 
+// Estimate the moments of d from n samples: the mean E[x], the second moment
+// E[x^2] and the inverse moment E[1/|x|]. SPSA needs the mean to be zero and
+// the other two to be bounded, which is how to validate a custom distribution.
+func CheckDistributionMoments(d PerturbationDistribution, n int) (mean, secondMoment, invMoment float64) {
+	for _, x := range SampleN(n, d) {
+		mean += x
+		secondMoment += x * x
+		invMoment += 1 / math.Abs(x)
+	}
+	return mean / float64(n), secondMoment / float64(n), invMoment / float64(n)
+}
+
 // The bernoulli +/- r distribution.
 type Bernoulli struct {
 	r float64
 }
 
 func (b Bernoulli) Sample() float64 {
-	if rand.Float32() > .5 {
+	return b.SampleFrom(globalSource{})
+}
+
+func (b Bernoulli) SampleFrom(src Source) float64 {
+	if src.Float64() > .5 {
 		return b.r
 	} else {
 		return -b.r
 	}
 }
 
+// The bernoulli +/- r distribution drawn from its own random number generator
+// instead of the global one, so that runs can be reproduced.
+type SeededBernoulli struct {
+	r   float64
+	rng *rand.Rand
+}
+
+// Create a bernoulli +/- r distribution with a private generator seeded by seed.
+func NewSeededBernoulli(r float64, seed int64) SeededBernoulli {
+	return SeededBernoulli{r, rand.New(rand.NewSource(seed))}
+}
+
+func (b SeededBernoulli) Sample() float64 {
+	if b.rng.Float32() > .5 {
+		return b.r
+	} else {
+		return -b.r
+	}
+}
+
+// A bernoulli-like +/- R distribution whose signs are supplied by the caller
+// and cycled through in order, for exact unit tests of the algorithm.
+type DeterministicBernoulli struct {
+	R     float64
+	signs []int
+	i     int
+}
+
+// Create a deterministic bernoulli +/- r distribution cycling through signs.
+func NewDeterministicBernoulli(r float64, signs ...int) *DeterministicBernoulli {
+	return &DeterministicBernoulli{R: r, signs: signs}
+}
+
+func (db *DeterministicBernoulli) Sample() float64 {
+	sign := db.signs[db.i%len(db.signs)]
+	db.i++
+	if sign < 0 {
+		return -db.R
+	}
+	return db.R
+}
+
+// A perturbation distribution that records every draw of D, so that a run can be
+// reproduced exactly with a Replayer of the Draws, independent of the random
+// number generator. Draws can be saved with Vector.MarshalCSV.
+type Recorder struct {
+	D     PerturbationDistribution
+	Draws Vector
+}
+
+func (r *Recorder) Sample() float64 {
+	x := r.D.Sample()
+	r.Draws = append(r.Draws, x)
+	return x
+}
+
+// A perturbation distribution that replays recorded Draws in order.
+// It panics when the draws are exhausted.
+type Replayer struct {
+	Draws Vector
+	i     int
+}
+
+func (r *Replayer) Sample() float64 {
+	if r.i >= len(r.Draws) {
+		panic(fmt.Sprintf("spsa: Replayer exhausted after %d draws", len(r.Draws)))
+	}
+	r.i++
+	return r.Draws[r.i-1]
+}
+
+// Create a pair of perturbation distributions for antithetic SPSA instances. The
+// n-th draw of either one is the negation of the n-th draw of the other, whichever
+// draws first. The draws come from d, which should be symmetric about zero.
+// The pair is safe to use from different goroutines.
+func NewAntitheticPair(d PerturbationDistribution) (PerturbationDistribution, PerturbationDistribution) {
+	p := &antitheticPair{d: d}
+	return antitheticHalf{p, 0}, antitheticHalf{p, 1}
+}
+
+type antitheticPair struct {
+	mu      sync.Mutex
+	d       PerturbationDistribution
+	pending [2][]float64
+}
+
+type antitheticHalf struct {
+	pair *antitheticPair
+	side int
+}
+
+func (h antitheticHalf) Sample() float64 {
+	p := h.pair
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if q := p.pending[h.side]; len(q) > 0 {
+		p.pending[h.side] = q[1:]
+		return q[0]
+	}
+	x := p.d.Sample()
+	p.pending[1-h.side] = append(p.pending[1-h.side], -x)
+	return x
+}
+
 // The segmented/mirrored uniform distribution. Samples with equal probability
 // all real numbers in [a,b] U [-b,-a] where 0 < a < b.
 type SegmentedUniform struct {
@@ -207,6 +1588,40 @@ type SegmentedUniform struct {
 }
 
 func (su SegmentedUniform) Sample() float64 {
-	r := rand.Float64() - .5
-	return math.Copysign(r, math.Abs(r)*2*(su.b-su.a)+su.a)
+	return su.SampleFrom(globalSource{})
+}
+
+func (su SegmentedUniform) SampleFrom(src Source) float64 {
+	r := src.Float64() - .5
+	return math.Copysign(math.Abs(r)*2*(su.b-su.a)+su.a, r)
+}
+
+// The symmetric triangular distribution. Samples all real numbers in
+// [a,b] U [-b,-a] where 0 < a < b, with a triangular density peaked at the
+// middle of each segment. Since a > 0, E[1/|X|] is bounded.
+type SymmetricTriangular struct {
+	a, b float64
+}
+
+func (st SymmetricTriangular) Sample() float64 {
+	return st.SampleFrom(globalSource{})
+}
+
+func (st SymmetricTriangular) SampleFrom(src Source) float64 {
+	m := st.a + (st.b-st.a)*(src.Float64()+src.Float64())/2
+	if src.Float64() > .5 {
+		return m
+	} else {
+		return -m
+	}
+}
+
+// Sample a vector from a slice of segmented uniform distributions, one per
+// coordinate, so that each coordinate's perturbation can match its own range.
+func SampleSegmentedUniform(su []SegmentedUniform) Vector {
+	a := make(Vector, len(su))
+	for i, d := range su {
+		a[i] = d.Sample()
+	}
+	return a
 }
@@ -3,10 +3,16 @@ package spsa
 import (
 	"math"
 	"math/rand"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
 
+// Two-sided version of close
+func near(a, b, eps float64) bool {
+	return math.Abs(a-b) < eps
+}
+
 //********** SPSA Implementation Example ***********
 
 // This example uses the helper function Optimize which shortens the boilerplate
@@ -61,6 +67,116 @@ func TestOptimizeAbsoluteSum(t *testing.T) {
 	}
 }
 
+func TestOptimizeSeeded(t *testing.T) {
+	a := OptimizeSeeded(AbsoluteSum, Vector{1, 1, 1, 1, 1}, 1000, 1, .1, 42)
+	b := OptimizeSeeded(AbsoluteSum, Vector{1, 1, 1, 1, 1}, 1000, 1, .1, 42)
+
+	if !reflect.DeepEqual(a, b) {
+		t.Error("OptimizeSeeded wasn't reproducible with the same seed.", a.String(), b.String())
+	} else if a.MeanSquare() > .001 {
+		t.Error("SPSA/OptimizeSeeded didn't optimize the AbsoluteSum function very well...", a.String())
+	}
+}
+
+func TestOptimizeOptions(t *testing.T) {
+	a := OptimizeOptions(AbsoluteSum, Vector{1, 1, 1, 1, 1}, Options{N: 1000, A: 1, C: .1, Seed: 42})
+	b := OptimizeSeeded(AbsoluteSum, Vector{1, 1, 1, 1, 1}, 1000, 1, .1, 42)
+
+	if !reflect.DeepEqual(a, b) {
+		t.Error("OptimizeOptions defaults didn't match OptimizeSeeded.", a.String(), b.String())
+	} else if a.MeanSquare() > .001 {
+		t.Error("SPSA/OptimizeOptions didn't optimize the AbsoluteSum function very well...", a.String())
+	}
+}
+
+func TestOptimizeTuned(t *testing.T) {
+	theta := OptimizeTuned(AbsoluteSum, Vector{1, 1, 1, 1, 1}, 1000, 1, .1, 1, .101)
+	if theta.MeanSquare() > .001 {
+		t.Error("SPSA/OptimizeTuned didn't optimize the AbsoluteSum function very well with alpha = 1...", theta.String())
+	}
+}
+
+func TestOptimizeShortRun(t *testing.T) {
+	if A := aBias(5); A <= 0 {
+		t.Error("The default A-bias for 5 rounds wasn't positive.", A)
+	} else if A := aBias(1000); A != 100 {
+		t.Error("The default A-bias for 1000 rounds wasn't 10% of the rounds.", A)
+	}
+
+	theta := OptimizeSeeded(AbsoluteSum, Vector{1, 1, 1, 1, 1}, 5, .1, .1, 42)
+	if AbsoluteSum(theta) > AbsoluteSum(Vector{1, 1, 1, 1, 1}) {
+		t.Error("A short run of SPSA diverged.", theta.String())
+	}
+}
+
+func TestOptimizeChecked(t *testing.T) {
+	theta, loss, ok := OptimizeChecked(AbsoluteSum, Vector{1, 1, 1, 1, 1}, 1000, 1, .1, .1)
+	if !ok {
+		t.Error("SPSA/OptimizeChecked didn't optimize the AbsoluteSum function below the threshold...", theta.String(), loss)
+	} else if loss != AbsoluteSum(theta) {
+		t.Error("OptimizeChecked didn't return the final loss.", loss, AbsoluteSum(theta))
+	}
+
+	if _, _, ok := OptimizeChecked(AbsoluteSum, Vector{1, 1, 1, 1, 1}, 1000, 1, .1, 0); ok {
+		t.Error("OptimizeChecked reported a loss below an unreachable threshold.")
+	}
+}
+
+func TestOptimizeHuber(t *testing.T) {
+	theta := Optimize(Huber(1), Vector{5, -5, 1, 1, 1}, 1000, 1, .1)
+	if theta.MeanSquare() > .001 {
+		t.Error("SPSA didn't optimize the Huber function very well...", theta.String())
+	}
+}
+
+func TestOptimizeLogCosh(t *testing.T) {
+	theta := Optimize(LogCosh(1), Vector{5, -5, 1, 1, 1}, 1000, 1, .1)
+	if theta.MeanSquare() > .001 {
+		t.Error("SPSA didn't optimize the LogCosh function very well...", theta.String())
+	}
+}
+
+func TestOptimizeQuadraticHessian(t *testing.T) {
+	H, b := Matrix{{2, 1}, {1, 3}}, Vector{1, 2}
+	L := QuadraticHessian(H, b)
+
+	// H^-1 b, where the gradient H theta - b vanishes
+	opt := Vector{.2, .6}
+	if g := H.MulVec(opt).Subtract(b); g.Norm() > 1e-12 {
+		t.Error("H^-1 b isn't a stationary point.", g.String())
+	}
+	for _, d := range []Vector{{.01, 0}, {0, .01}, {-.01, .01}} {
+		if L(opt.Add(d)) <= L(opt) {
+			t.Error("H^-1 b isn't the minimum of QuadraticHessian.", d.String())
+		}
+	}
+
+	theta := Optimize(L, Vector{3, -3}, 1000, .5, .1)
+	if d := theta.Distance(opt); d > .01 {
+		t.Error("SPSA didn't optimize the QuadraticHessian function very well...", theta.String(), d)
+	}
+}
+
+func TestSPSARastrigin(t *testing.T) {
+	// Starting inside the global basin. Far starts get stuck in local minima.
+	theta := Optimize(Rastrigin, Vector{.3, -.3, .2, -.2, .1}, 1000, .005, .02)
+	for _, x := range theta {
+		if math.Abs(x) > .5 {
+			t.Error("SPSA left the global basin of the Rastrigin function.", theta.String())
+		}
+	}
+	if Rastrigin(theta) > .01 {
+		t.Error("SPSA didn't optimize the Rastrigin function very well...", theta.String(), Rastrigin(theta))
+	}
+}
+
+func TestOptimizeWith(t *testing.T) {
+	theta := OptimizeWith(AbsoluteSum, Vector{1, 1, 1, 1, 1}, 1000, 1, .1, SegmentedUniform{.5, 1.5})
+	if theta.MeanSquare() > .001 {
+		t.Error("SPSA/OptimizeWith didn't optimize the AbsoluteSum function very well...", theta.String())
+	}
+}
+
 func TestSPSARosenbrock(t *testing.T) {
 	theta := Optimize(Rosenbrock, Vector{.99, 1, .99, 1, .99, 1, .99, 1, .99, 1}, 10000, .002, .05)
 	if Rosenbrock(theta) > .001 {
@@ -68,6 +184,553 @@ func TestSPSARosenbrock(t *testing.T) {
 	}
 }
 
+func TestRunChecked(t *testing.T) {
+	spsa := &SPSA{
+		L:     AbsoluteSum,
+		C:     NoConstraints,
+		Theta: Vector{1, 1, 1, 1, 1},
+		Ak:    StandardAk(1, 100, .602),
+		Ck:    StandardCk(.1, .101),
+		Delta: Bernoulli{1},
+	}
+
+	theta, err := spsa.RunChecked(1000)
+	if err != nil {
+		t.Error("RunChecked returned an error on a valid SPSA.", err)
+	} else if theta.MeanSquare() > .001 {
+		t.Error("SPSA/RunChecked didn't optimize the AbsoluteSum function very well...", theta.String())
+	}
+}
+
+func TestRunCheckedDivergence(t *testing.T) {
+	spsa := &SPSA{
+		L:               func(v Vector) float64 { return v.MeanSquare() },
+		C:               NoConstraints,
+		Theta:           Vector{1, 1, 1, 1, 1},
+		Ak:              StandardAk(1e6, 0, .602),
+		Ck:              StandardCk(.1, .101),
+		Delta:           Bernoulli{1},
+		DivergenceLimit: 1e3,
+	}
+
+	theta, err := spsa.RunChecked(1000)
+	if err == nil {
+		t.Error("RunChecked didn't report divergence.", theta.String())
+	} else if theta.Norm() > 1e3 {
+		t.Error("RunChecked didn't return the last theta within the limit.", theta.String())
+	} else if spsa.k >= 1000 {
+		t.Error("RunChecked didn't stop when theta diverged.", spsa.k)
+	}
+}
+
+func TestRunBudget(t *testing.T) {
+	for _, budget := range []int{0, 1, 99, 100, 101} {
+		spsa := &SPSA{
+			L:     AbsoluteSum,
+			C:     NoConstraints,
+			Theta: Vector{1, 1, 1, 1, 1},
+			Ak:    StandardAk(1, 100, .602),
+			Ck:    StandardCk(.1, .101),
+			Delta: Bernoulli{1},
+		}
+		spsa.RunBudget(budget)
+
+		if spsa.Evaluations() > budget {
+			t.Error("RunBudget exceeded its evaluation budget.", budget, spsa.Evaluations())
+		} else if spsa.Evaluations() <= budget-2 {
+			t.Error("RunBudget stopped more than a round short of its budget.", budget, spsa.Evaluations())
+		}
+	}
+}
+
+func TestRunBudgetSteps(t *testing.T) {
+	diverging := &SPSA{
+		L:               func(v Vector) float64 { return -v.Dot(v) },
+		C:               NoConstraints,
+		Theta:           Vector{1, 1},
+		Ak:              StandardAk(1, 10, .602),
+		Ck:              StandardCk(.1, .101),
+		Delta:           Bernoulli{1},
+		DivergenceLimit: 100,
+	}
+	if theta := diverging.RunBudget(400); theta.Norm() > 100 || diverging.Evaluations() >= 400 {
+		t.Error("RunBudget didn't stop when theta diverged.", theta.String(), diverging.Evaluations())
+	}
+
+	averaged := &SPSA{
+		L:              AbsoluteSum,
+		C:              NoConstraints,
+		Theta:          Vector{1, 1, 1},
+		Ak:             StandardAk(.1, 10, .602),
+		Ck:             StandardCk(.1, .101),
+		Delta:          Bernoulli{1},
+		PolyakFraction: .5,
+	}
+	averaged.RunBudget(40)
+	if averaged.avgN != 10 {
+		t.Error("RunBudget didn't average the final half of its rounds.", averaged.avgN)
+	}
+
+	// The checkpoint directory doesn't exist, so the first write fails
+	checkpointed := &SPSA{
+		L:               AbsoluteSum,
+		C:               NoConstraints,
+		Theta:           Vector{1, 1, 1},
+		Ak:              StandardAk(.1, 10, .602),
+		Ck:              StandardCk(.1, .101),
+		Delta:           Bernoulli{1},
+		CheckpointEvery: 2,
+		CheckpointPath:  filepath.Join("no", "such", "dir", "checkpoint.json"),
+	}
+	checkpointed.RunBudget(40)
+	if checkpointed.k != 2 {
+		t.Error("RunBudget didn't stop when a checkpoint write failed.", checkpointed.k)
+	}
+}
+
+func TestRunBudgetTrackLoss(t *testing.T) {
+	for _, budget := range []int{2, 3, 100} {
+		spsa := &SPSA{
+			L:         AbsoluteSum,
+			C:         NoConstraints,
+			Theta:     Vector{1, 1, 1, 1, 1},
+			Ak:        StandardAk(1, 100, .602),
+			Ck:        StandardCk(.1, .101),
+			Delta:     Bernoulli{1},
+			TrackLoss: true,
+		}
+		spsa.RunBudget(budget)
+
+		if spsa.Evaluations() > budget {
+			t.Error("RunBudget exceeded its evaluation budget with TrackLoss.", budget, spsa.Evaluations())
+		} else if spsa.Evaluations() <= budget-3 {
+			t.Error("RunBudget stopped more than a round short of its budget.", budget, spsa.Evaluations())
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() *SPSA {
+		return &SPSA{
+			L:     AbsoluteSum,
+			C:     NoConstraints,
+			Theta: Vector{1, 1},
+			Ak:    StandardAk(1, 100, .602),
+			Ck:    StandardCk(.1, .101),
+			Delta: Bernoulli{1},
+		}
+	}
+
+	if err := valid().Validate(); err != nil {
+		t.Error("Validate rejected a valid SPSA.", err)
+	}
+
+	cases := map[string]func(*SPSA){
+		"Theta": func(s *SPSA) { s.Theta = Vector{} },
+		"L":     func(s *SPSA) { s.L = nil },
+		"Ak":    func(s *SPSA) { s.Ak = nil },
+		"Ck":    func(s *SPSA) { s.Ck = nil },
+		"Delta": func(s *SPSA) { s.Delta = nil },
+		"C":     func(s *SPSA) { s.C = nil },
+
+		"CheckpointPath": func(s *SPSA) { s.CheckpointEvery = 10 },
+		"AdaptAk": func(s *SPSA) {
+			s.AkVector = StandardAkVector(Vector{1, 1}, 100, .602)
+			s.AdaptAk = &AdaptiveAk{A: .1, Target: .6, Factor: 1.2, Window: 5}
+		},
+	}
+	for field, unset := range cases {
+		spsa := valid()
+		unset(spsa)
+		if err := spsa.Validate(); err == nil {
+			t.Error("Validate didn't catch a missing field:", field)
+		}
+		if _, err := spsa.RunChecked(10); err == nil {
+			t.Error("RunChecked didn't catch a missing field:", field)
+		}
+	}
+}
+
+func TestSPSASignUpdate(t *testing.T) {
+	// A quadratic whose gradient is tiny compared to the scale of theta.
+	flat := func(v Vector) float64 {
+		return 1e-4 * (math.Pow(v[0], 2) + math.Pow(v[1], 2))
+	}
+	newSPSA := func(sign bool) *SPSA {
+		return &SPSA{
+			L:          flat,
+			C:          NoConstraints,
+			Theta:      Vector{1, 1},
+			Ak:         StandardAk(.1, 100, .602),
+			Ck:         StandardCk(.1, .101),
+			Delta:      Bernoulli{1},
+			SignUpdate: sign,
+		}
+	}
+
+	raw := newSPSA(false).Run(1000)
+	signed := newSPSA(true).Run(1000)
+
+	if raw.MeanSquare() < .9 {
+		t.Error("Raw SPSA update was expected to stall on the flat quadratic.", raw.String())
+	}
+	if signed.MeanSquare() > .01 {
+		t.Error("SPSA sign update didn't optimize the flat quadratic very well...", signed.String())
+	}
+}
+
+func TestSPSAPolish(t *testing.T) {
+	quadratic := func(v Vector) float64 {
+		return v.MeanSquare() * float64(len(v))
+	}
+	spsa := &SPSA{
+		L:     quadratic,
+		C:     NoConstraints,
+		Theta: Vector{1, -2, 3, -4, 5},
+		Ak:    StandardAk(.1, 10, .602),
+		Ck:    StandardCk(.1, .101),
+		Delta: Bernoulli{1},
+	}
+
+	before := quadratic(spsa.Run(20))
+	after := quadratic(spsa.Polish(30))
+
+	if after > before {
+		t.Error("Polish made the loss worse.", before, after)
+	}
+}
+
+func TestSPSAAntithetic(t *testing.T) {
+	quadratic := func(v Vector) float64 {
+		return v.MeanSquare() * float64(len(v))
+	}
+	// Variance between the two gradient estimates of each pair of rounds at a fixed theta
+	pairVariance := func(antithetic bool) (x float64) {
+		spsa := &SPSA{
+			L:          quadratic,
+			Theta:      Vector{1, -2, 3, -4, 5},
+			Ck:         StandardCk(.1, .101),
+			Delta:      Bernoulli{1},
+			Antithetic: antithetic,
+		}
+		for i := 0; i < 100; i++ {
+			diff := spsa.estimateGradient().Subtract(spsa.estimateGradient())
+			x += diff.MeanSquare() / 2
+		}
+		return x / 100
+	}
+
+	with, without := pairVariance(true), pairVariance(false)
+	if with > 1e-12 {
+		t.Error("Antithetic pairs at a fixed theta didn't agree.", with)
+	} else if without <= with {
+		t.Error("Independent pairs were expected to vary more than antithetic pairs.", without, with)
+	}
+}
+
+func TestSPSAPerturbFraction(t *testing.T) {
+	quadratic := func(v Vector) float64 {
+		return v.MeanSquare() * float64(len(v))
+	}
+	theta0 := make(Vector, 200)
+	for i := range theta0 {
+		theta0[i] = 1
+	}
+
+	spsa := &SPSA{
+		L:               quadratic,
+		C:               NoConstraints,
+		Theta:           theta0,
+		Ak:              StandardAk(.5, 500, .602),
+		Ck:              StandardCk(.1, .101),
+		Delta:           Bernoulli{1},
+		PerturbFraction: .1,
+	}
+
+	final := spsa.Run(5000)
+	if final.MeanSquare() > .01 {
+		t.Error("Sparse SPSA didn't optimize the high dimensional quadratic very well...", final.MeanSquare())
+	}
+}
+
+func TestSPSAEpsFloor(t *testing.T) {
+	noisy := func(v Vector) float64 {
+		return AbsoluteSum(v) + (rand.Float64()-.5)*1e-3
+	}
+	spsa := &SPSA{
+		L:        noisy,
+		Theta:    Vector{1, 1, 1, 1, 1},
+		Ck:       StandardCk(1e-9, .101),
+		Delta:    Bernoulli{1},
+		EpsFloor: .01,
+	}
+
+	for i := 0; i < 100; i++ {
+		for _, g := range spsa.estimateGradient() {
+			if math.Abs(g) > .1 {
+				t.Error("EpsFloor didn't bound the gradient component with a tiny ck.", g)
+			}
+		}
+	}
+}
+
+func TestSPSAConstrainPerturbations(t *testing.T) {
+	bc := BoundedConstraints{{0, 1}, {0, 1}, {0, 1}}
+	L := func(v Vector) float64 {
+		for _, x := range v {
+			if x < 0 || x > 1 {
+				t.Fatal("Loss was evaluated outside the bounds.", v.String())
+			}
+		}
+		return AbsoluteSum(v)
+	}
+
+	spsa := &SPSA{
+		L:                      L,
+		C:                      bc.Constrain,
+		Theta:                  Vector{.05, .5, .95},
+		Ak:                     StandardAk(.1, 10, .602),
+		Ck:                     StandardCk(.2, .101),
+		Delta:                  Bernoulli{1},
+		ConstrainPerturbations: true,
+	}
+
+	spsa.Run(100)
+}
+
+func TestSPSAAfterUpdate(t *testing.T) {
+	spsa := &SPSA{
+		L:     AbsoluteSum,
+		C:     NoConstraints,
+		Theta: Vector{1, 1, 1},
+		Ak:    StandardAk(.01, 10, .602),
+		Ck:    StandardCk(.1, .101),
+		Delta: Bernoulli{1},
+	}
+	spsa.AfterUpdate = func(round int, theta Vector) Vector {
+		if round > 50 {
+			theta[0] = 0
+		}
+		return theta
+	}
+
+	for i := 1; i <= 100; i++ {
+		spsa.Run(1)
+		if i > 50 && spsa.Theta[0] != 0 {
+			t.Error("Coordinate zeroed by AfterUpdate didn't stay zero.", i, spsa.Theta.String())
+		} else if i <= 50 && spsa.Theta[0] == 0 {
+			t.Error("AfterUpdate zeroed a coordinate too early.", i, spsa.Theta.String())
+		}
+	}
+}
+
+func TestSPSAPolyakAverage(t *testing.T) {
+	noisy := func(v Vector) float64 {
+		return v.MeanSquare()*float64(len(v)) + rand.NormFloat64()*.1
+	}
+
+	var last, averaged float64
+	for trial := 0; trial < 20; trial++ {
+		spsa := &SPSA{
+			L:              noisy,
+			C:              NoConstraints,
+			Theta:          Vector{1, 1, 1, 1, 1},
+			Ak:             StandardAk(.3, 100, .602),
+			Ck:             StandardCk(.1, .101),
+			Delta:          Bernoulli{1},
+			PolyakFraction: .5,
+		}
+		last += spsa.Run(1000).MeanSquare()
+		averaged += spsa.AveragedTheta().MeanSquare()
+	}
+
+	if averaged >= last {
+		t.Error("Polyak averaged theta didn't have lower error than the last iterate.", averaged, last)
+	}
+}
+
+func TestSPSAConsistentCk(t *testing.T) {
+	var evals Vector
+	spsa := &SPSA{
+		L: func(v Vector) float64 {
+			evals = append(evals, v[0])
+			return 3 * v[0]
+		},
+		Theta: Vector{1},
+		Ck:    SliceGain([]float64{.1, .2, .3, .4}),
+		Delta: Bernoulli{1},
+	}
+
+	for k, ck := range []float64{.1, .2, .3, .4} {
+		evals = nil
+		grad := spsa.estimateGradient()
+		if !near(math.Abs(evals[0]-1), ck, 1e-9) || !near(math.Abs(evals[1]-1), ck, 1e-9) {
+			t.Error("Plus and minus perturbations didn't use the same ck.", k, evals.String())
+		} else if !near(evals[0]+evals[1], 2, 1e-9) {
+			t.Error("Plus and minus perturbations weren't symmetric about theta.", k, evals.String())
+		} else if !near(grad[0], 3, 1e-9) {
+			t.Error("Gradient denominator didn't use the perturbation's ck.", k, grad.String())
+		}
+	}
+}
+
+func TestSPSALastGains(t *testing.T) {
+	spsa := &SPSA{
+		L:     AbsoluteSum,
+		C:     NoConstraints,
+		Theta: Vector{1, 1, 1},
+		Ak:    StandardAk(1, 100, .602),
+		Ck:    StandardCk(.1, .101),
+		Delta: Bernoulli{1},
+	}
+	spsa.Run(1)
+
+	if spsa.LastAk != <-StandardAk(1, 100, .602) {
+		t.Error("LastAk isn't the first value of the ak sequence.", spsa.LastAk)
+	} else if spsa.LastCk != <-StandardCk(.1, .101) {
+		t.Error("LastCk isn't the first value of the ck sequence.", spsa.LastCk)
+	}
+}
+
+func TestSPSAEstimateGradient(t *testing.T) {
+	spsa := &SPSA{
+		L:     AbsoluteSum,
+		Theta: Vector{1, 2, 3, 4},
+		Ck:    StandardCk(.1, .101),
+		Delta: Bernoulli{1},
+	}
+
+	if grad := spsa.EstimateGradient(); len(grad) != 4 {
+		t.Error("EstimateGradient returned the wrong dimension.", grad.String())
+	} else if !reflect.DeepEqual(spsa.Theta, Vector{1, 2, 3, 4}) {
+		t.Error("EstimateGradient changed theta.")
+	}
+}
+
+func TestSPSADirections(t *testing.T) {
+	quadratic := func(v Vector) float64 {
+		return v.MeanSquare() * float64(len(v))
+	}
+	// Mean squared error of the gradient estimate at a fixed theta
+	variance := func(directions int) (x float64) {
+		spsa := &SPSA{
+			L:          quadratic,
+			Theta:      Vector{1, -2, 3, -4, 5},
+			Ck:         StandardCk(.1, .101),
+			Delta:      Bernoulli{1},
+			Directions: directions,
+		}
+		for i := 0; i < 1000; i++ {
+			x += spsa.estimateGradient().Subtract(Vector{2, -4, 6, -8, 10}).MeanSquare()
+		}
+		return x / 1000
+	}
+
+	if ratio := variance(4) / variance(1); ratio < .15 || ratio > .4 {
+		t.Error("Gradient variance didn't decrease like 1/Directions.", ratio)
+	}
+}
+
+func TestSPSAAdaGrad(t *testing.T) {
+	// Only the first coordinate has a (small) gradient.
+	sparse := func(v Vector) float64 {
+		return 1e-3 * math.Pow(v[0], 2)
+	}
+	newSPSA := func(adaGrad bool) *SPSA {
+		return &SPSA{
+			L:       sparse,
+			C:       NoConstraints,
+			Theta:   Vector{1, 0, 0, 0, 0},
+			Ak:      StandardAk(1, 100, .602),
+			Ck:      StandardCk(.1, .101),
+			Delta:   Bernoulli{1},
+			AdaGrad: adaGrad,
+		}
+	}
+
+	uniform := sparse(newSPSA(false).Run(1000))
+	adaptive := sparse(newSPSA(true).Run(1000))
+
+	if adaptive > uniform/10 {
+		t.Error("AdaGrad didn't converge faster than a uniform ak on the sparse problem.", adaptive, uniform)
+	}
+}
+
+func TestSPSADeltaFilter(t *testing.T) {
+	spsa := &SPSA{
+		L:     AbsoluteSum,
+		Theta: Vector{1, 2, 3},
+		Ck:    StandardCk(.1, .101),
+		Delta: Bernoulli{1},
+		DeltaFilter: func(delta Vector) Vector {
+			delta[0] = 0
+			return delta
+		},
+	}
+
+	for i := 0; i < 100; i++ {
+		if grad := spsa.EstimateGradient(); grad[0] != 0 {
+			t.Error("Gradient of a coordinate frozen by DeltaFilter isn't zero.", grad.String())
+		}
+	}
+}
+
+func TestSPSAFrozen(t *testing.T) {
+	spsa := &SPSA{
+		L:      AbsoluteSum,
+		C:      NoConstraints,
+		Theta:  Vector{1, 1, 1, 1, 1},
+		Ak:     StandardAk(1, 100, .602),
+		Ck:     StandardCk(.1, .101),
+		Delta:  Bernoulli{1},
+		Frozen: []bool{true, false, true},
+	}
+
+	for i := 0; i < 1000; i++ {
+		spsa.Run(1)
+		if spsa.Theta[0] != 1 || spsa.Theta[2] != 1 {
+			t.Fatal("Frozen coordinates didn't keep their initial values.", spsa.Theta.String())
+		}
+	}
+	if math.Abs(spsa.Theta[1])+math.Abs(spsa.Theta[3])+math.Abs(spsa.Theta[4]) > .1 {
+		t.Error("SPSA didn't optimize the unfrozen coordinates very well...", spsa.Theta.String())
+	}
+}
+
+func TestSPSAAkVector(t *testing.T) {
+	// The first coordinate is 100 times more curved than the second.
+	anisotropic := func(v Vector) float64 {
+		return 100*math.Pow(v[0], 2) + math.Pow(v[1], 2)
+	}
+
+	scalar := &SPSA{
+		L:     anisotropic,
+		C:     NoConstraints,
+		Theta: Vector{1, 1},
+		Ak:    StandardAk(.005, 10, .602),
+		Ck:    StandardCk(.1, .101),
+		Delta: Bernoulli{1},
+	}
+	vector := &SPSA{
+		L:        anisotropic,
+		C:        NoConstraints,
+		Theta:    Vector{1, 1},
+		AkVector: StandardAkVector(Vector{.005, .5}, 10, .602),
+		Ck:       StandardCk(.1, .101),
+		Delta:    Bernoulli{1},
+	}
+
+	if err := vector.Validate(); err != nil {
+		t.Error("Validate rejected a vector gain in place of Ak.", err)
+	}
+
+	scalarLoss := anisotropic(scalar.Run(300))
+	vectorLoss := anisotropic(vector.Run(300))
+	if vectorLoss > scalarLoss/100 {
+		t.Error("Per-coordinate ak didn't converge faster than a scalar ak.", vectorLoss, scalarLoss)
+	}
+}
+
 //********** Constraint function Testing ************
 
 func TestNoConstraints(t *testing.T) {
@@ -88,31 +751,509 @@ func TestBoundedConstraints(t *testing.T) {
 	}
 }
 
+func TestBoundedConstraintsValidate(t *testing.T) {
+	bc := BoundedConstraints{{0, 10}, {5, 10}, {-5, 0}}
+	if err := bc.Validate(3); err != nil {
+		t.Error("Bounded Constraints Validate rejected matching bounds.", err)
+	}
+	if err := bc.Validate(5); err == nil {
+		t.Error("Bounded Constraints Validate didn't catch a dimension mismatch.")
+	}
+	if err := (BoundedConstraints{{1, 0}}).Validate(1); err == nil {
+		t.Error("Bounded Constraints Validate didn't catch inverted bounds.")
+	}
+}
+
+func TestBoundedConstraintsNaN(t *testing.T) {
+	bc := BoundedConstraints{{0, 10}, {5, 10}, {math.Inf(-1), 3}}
+	theta := bc.Constrain(Vector{math.NaN(), 7, math.NaN()})
+
+	if !reflect.DeepEqual(theta, Vector{5, 7, 3}) {
+		t.Error("Bounded Constraints didn't map NaN to the midpoint of the bounds.", theta.String())
+	}
+
+	lbc := LogBoundedConstraints{{0, 2}}
+	if theta := lbc.Constrain(Vector{math.NaN()}); !near(theta[0], math.E, 1e-12) {
+		t.Error("Log Bounded Constraints didn't map NaN to the midpoint of the bounds.", theta.String())
+	}
+}
+
+func TestBoundedConstraintsReflect(t *testing.T) {
+	bc := BoundedConstraints{{0, 1}, {0, 1}, {0, 1}, {0, math.Inf(1)}, {0, 1}}
+	theta := bc.Reflect(Vector{1.25, -.25, .5, -2, 3.25})
+
+	want := Vector{.75, .25, .5, 2, .75}
+	for i := range want {
+		if !near(theta[i], want[i], 1e-12) {
+			t.Error("Bounded Constraints didn't reflect into the bounds.", theta.String())
+			break
+		}
+	}
+}
+
+func TestSPSAReflectConstraint(t *testing.T) {
+	bc := BoundedConstraints{{0, 1}}
+	var points []float64
+	spsa := &SPSA{
+		L: func(v Vector) float64 {
+			points = append(points, v[0])
+			return AbsoluteSum(v)
+		},
+		C:                 bc.Constrain,
+		Theta:             Vector{.98},
+		Ak:                SliceGain([]float64{0}),
+		Ck:                SliceGain([]float64{.1}),
+		Delta:             Bernoulli{1},
+		ReflectConstraint: bc,
+	}
+	spsa.Run(1)
+
+	// The perturbation past the upper bound by .08 is reflected to .08 inside it
+	// instead of being clamped to the bound
+	for _, p := range points {
+		if p < 0 || p > 1 {
+			t.Error("A reflected perturbation wasn't feasible.", p)
+		} else if d := math.Abs(p - .98); !near(d, .1, 1e-12) && !near(1-p, .08, 1e-12) {
+			t.Error("A reflected perturbation didn't keep its magnitude.", p)
+		}
+	}
+}
+
+func TestBoundedConstraintsMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Bounded Constraints didn't panic on a dimension mismatch.")
+		}
+	}()
+	bc := BoundedConstraints{{0, 10}, {5, 10}}
+	bc.Constrain(Vector{1, 2, 3, 4, 5})
+}
+
+func TestAdaptiveCk(t *testing.T) {
+	linear := func(v Vector) float64 { return 3 * v[0] }
+	newSPSA := func(L LossFunction, ac *AdaptiveCk) *SPSA {
+		return &SPSA{
+			L:       L,
+			C:       NoConstraints,
+			Theta:   Vector{1},
+			Ak:      StandardAk(.001, 10, .602),
+			AdaptCk: ac,
+			Delta:   NewSeededBernoulli(1, 1),
+		}
+	}
+
+	// Exact estimates of a linear loss always agree, so ck shrinks to Min
+	exact := &AdaptiveCk{C: 1, Min: .01, Max: 10, Factor: 1.1, Tolerance: .5}
+	spsa := newSPSA(linear, exact)
+	if err := spsa.Validate(); err != nil {
+		t.Error("Validate rejected an adaptive ck in place of Ck.", err)
+	}
+	spsa.Run(100)
+	if exact.C != exact.Min {
+		t.Error("Adaptive ck didn't shrink with agreeing gradient estimates.", exact.C)
+	}
+
+	// Noise dominates the estimates at a small ck, so ck grows until the
+	// estimates mostly agree and then hovers there
+	noisy := &AdaptiveCk{C: .001, Min: .0001, Max: 10, Factor: 1.1, Tolerance: .5}
+	rng := rand.New(rand.NewSource(1))
+	spsa = newSPSA(func(v Vector) float64 { return linear(v) + .1*rng.NormFloat64() }, noisy)
+	spsa.Run(300)
+	if noisy.C < .01 {
+		t.Error("Adaptive ck didn't grow with noisy gradient estimates.", noisy.C)
+	}
+	for i := 0; i < 200; i++ {
+		spsa.Run(1)
+		if noisy.C < .005 || noisy.C > .5 {
+			t.Error("Adaptive ck didn't settle with noisy gradient estimates.", i, noisy.C)
+			break
+		}
+	}
+}
+
+func TestAdaptiveAk(t *testing.T) {
+	aa := &AdaptiveAk{A: .01, Target: .6, Factor: 1.2, Window: 10}
+	spsa := &SPSA{
+		L: func(v Vector) (a float64) {
+			for i, x := range v {
+				a += float64(i+1) * x * x
+			}
+			return a
+		},
+		C:       NoConstraints,
+		Theta:   Vector{1, -2, 3, -4, 5},
+		AdaptAk: aa,
+		Ck:      StandardCk(.01, .101),
+		Delta:   Bernoulli{1},
+	}
+
+	if err := spsa.Validate(); err != nil {
+		t.Error("Validate rejected an adaptive ak in place of Ak.", err)
+	}
+
+	spsa.Run(300)
+	lo, hi, last := aa.A, aa.A, spsa.LastLoss
+	var improved float64
+	for i := 0; i < 400; i++ {
+		spsa.Run(1)
+		lo, hi = math.Min(lo, aa.A), math.Max(hi, aa.A)
+		if spsa.LastLoss < last {
+			improved++
+		}
+		last = spsa.LastLoss
+	}
+
+	if hi/lo > 8 {
+		t.Error("Adaptive ak didn't stabilize.", lo, hi)
+	} else if r := improved / 400; !near(r, aa.Target, .05) {
+		t.Error("Adaptive ak didn't reach the target improvement ratio.", r)
+	} else if !near(spsa.LastAk, aa.A, .2*aa.A) {
+		t.Error("SPSA didn't step with the adaptive ak.", spsa.LastAk, aa.A)
+	}
+}
+
+func TestLogBoundedConstraints(t *testing.T) {
+	lbc := LogBoundedConstraints{{math.Log(1e-5), math.Log(1e-1)}}
+	for _, x := range []float64{1e-9, 1e-5, 1e-3, 1e-1, 10, 0, -1} {
+		y := lbc.Constrain(Vector{x})[0]
+		if y < 1e-5*(1-1e-9) || y > 1e-1*(1+1e-9) {
+			t.Error("Log Bounded Constraints didn't keep the value in bounds.", x, y)
+		} else if x >= 1e-5 && x <= 1e-1 && !near(x, y, 1e-12) {
+			t.Error("Log Bounded Constraints changed a value in bounds.", x, y)
+		}
+	}
+}
+
+func TestOneHotConstraint(t *testing.T) {
+	oh := OneHotConstraint{3, 2}
+	b := oh.Constrain(Vector{.2, .7, -1, 3, 2.5})
+
+	if !reflect.DeepEqual(b, Vector{0, 1, 0, 1, 0}) {
+		t.Error("One-hot Constraint didn't operate correctly", b.String())
+	}
+}
+
+func TestOneHotConstraintEmptyBlock(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("One-hot Constraint didn't panic on an empty block.")
+		}
+	}()
+	OneHotConstraint{2, 0, 1}.Constrain(Vector{1, 2, 3})
+}
+
 //********** Perturbation Distribution Testing *************
 
 func TestBernoulli(t *testing.T) {
 	testPerturbationDistribution(t, Bernoulli{1})
 }
 
+func TestSeededBernoulli(t *testing.T) {
+	testPerturbationDistribution(t, NewSeededBernoulli(1, 7))
+
+	if !reflect.DeepEqual(SampleN(100, NewSeededBernoulli(1, 7)), SampleN(100, NewSeededBernoulli(1, 7))) {
+		t.Error("SeededBernoulli wasn't reproducible with the same seed.")
+	}
+}
+
+func TestAntitheticPair(t *testing.T) {
+	a, b := NewAntitheticPair(SegmentedUniform{.5, 1.5})
+	testPerturbationDistribution(t, a)
+	testPerturbationDistribution(t, b)
+
+	// Draw in uneven bursts from both sides
+	var as, bs Vector
+	for i := 0; i < 100; i++ {
+		as = append(as, SampleN(i%3, a)...)
+		bs = append(bs, SampleN(i%4, b)...)
+	}
+	as = append(as, SampleN(len(bs)-len(as), a)...)
+
+	for i := range as {
+		if as[i] != -bs[i] {
+			t.Error("Antithetic pair draws weren't exact negations.", i, as[i], bs[i])
+		}
+	}
+}
+
+func TestDeterministicBernoulli(t *testing.T) {
+	db := NewDeterministicBernoulli(2, 1, -1, -1)
+	if s := SampleN(5, db); !reflect.DeepEqual(s, Vector{2, -2, -2, 2, -2}) {
+		t.Error("DeterministicBernoulli didn't cycle through its signs.", s.String())
+	}
+}
+
+func TestSPSARoundByHand(t *testing.T) {
+	spsa := &SPSA{
+		L:     func(v Vector) float64 { return v[0]*v[0] + 3*v[1] },
+		C:     NoConstraints,
+		Theta: Vector{1, 2},
+		Ak:    SliceGain([]float64{.5}),
+		Ck:    SliceGain([]float64{.1}),
+		Delta: NewDeterministicBernoulli(1, 1, -1),
+	}
+
+	// delta = (.1, -.1), L(1.1, 1.9) = 6.91, L(.9, 2.1) = 7.11
+	// grad = (-.2 / .2, -.2 / -.2) = (-1, 1), theta = (1, 2) - .5 * (-1, 1)
+	theta := spsa.Run(1)
+	if !near(theta[0], 1.5, 1e-12) || !near(theta[1], 1.5, 1e-12) {
+		t.Error("One round of SPSA didn't match the hand computation.", theta)
+	}
+}
+
+func TestSPSATrackLoss(t *testing.T) {
+	var thetas []Vector
+	spsa := &SPSA{
+		L:         AbsoluteSum,
+		C:         NoConstraints,
+		Theta:     Vector{1, 1, 1, 1, 1},
+		Ak:        StandardAk(1, 100, .602),
+		Ck:        StandardCk(.1, .101),
+		Delta:     Bernoulli{1},
+		TrackLoss: true,
+		AfterUpdate: func(round int, theta Vector) Vector {
+			thetas = append(thetas, theta.Copy())
+			return theta
+		},
+	}
+	spsa.Run(20)
+
+	if len(spsa.LossHistory) != 20 {
+		t.Fatal("TrackLoss didn't record the loss every round.", len(spsa.LossHistory))
+	} else if spsa.Evaluations() != 60 {
+		t.Error("TrackLoss didn't cost exactly one extra evaluation per round.", spsa.Evaluations())
+	}
+	for i, loss := range spsa.LossHistory {
+		if loss != AbsoluteSum(thetas[i]) {
+			t.Error("TrackLoss didn't record the loss at theta.", i, loss, AbsoluteSum(thetas[i]))
+		}
+	}
+	if spsa.LastLoss != AbsoluteSum(spsa.Theta) {
+		t.Error("LastLoss isn't the loss at the final theta.", spsa.LastLoss)
+	}
+}
+
+func TestSPSATrustRadius(t *testing.T) {
+	var prev Vector
+	spsa := &SPSA{
+		L:           Rosenbrock,
+		C:           NoConstraints,
+		Theta:       Vector{-1, 2, -1, 2},
+		Ak:          StandardAk(.5, 10, .602),
+		Ck:          StandardCk(.1, .101),
+		Delta:       Bernoulli{1},
+		TrustRadius: .05,
+	}
+	spsa.AfterUpdate = func(round int, theta Vector) Vector {
+		if d := theta.Subtract(prev).Norm(); d > spsa.TrustRadius+1e-12 {
+			t.Error("A round moved theta farther than the trust radius.", round, d)
+		}
+		prev = theta.Copy()
+		return theta
+	}
+	prev = spsa.Theta.Copy()
+	spsa.Run(100)
+
+	if d := spsa.Theta.Subtract(Vector{-1, 2, -1, 2}).Norm(); d > 100*spsa.TrustRadius {
+		t.Error("SPSA with a trust radius moved too far in total.", d)
+	}
+}
+
+func TestSPSAPerturbScale(t *testing.T) {
+	// A noisy quadratic with gradient (2, 2) at theta
+	L := WithNoise(func(v Vector) float64 { return v.Dot(v) }, .5)
+	newSPSA := func(ck float64, pk GainSequence) *SPSA {
+		return &SPSA{
+			L:            L,
+			C:            NoConstraints,
+			Theta:        Vector{1, 1},
+			Ck:           SliceGain([]float64{ck}),
+			PerturbScale: pk,
+			Delta:        Bernoulli{1},
+		}
+	}
+	sample := func(spsa *SPSA) (st OnlineStats) {
+		for i := 0; i < 2000; i++ {
+			st.Add(spsa.EstimateGradient()[0])
+		}
+		return st
+	}
+
+	standard := sample(newSPSA(.05, nil))
+	decoupled := sample(newSPSA(.1, SliceGain([]float64{.05})))
+
+	if !near(standard.Mean(), 2, 1) {
+		t.Error("The standard estimate was biased.", standard.Mean())
+	} else if !near(decoupled.Mean(), 1, .5) {
+		t.Error("The decoupled estimate wasn't scaled by pk / ck.", decoupled.Mean())
+	} else if decoupled.Var() > standard.Var()/2 {
+		t.Error("The decoupled estimate didn't have a lower variance.", decoupled.Var(), standard.Var())
+	}
+}
+
+// A Source cycling through a fixed sequence
+type cycleSource struct {
+	values []float64
+	i      int
+}
+
+func (cs *cycleSource) Float64() float64 {
+	cs.i++
+	return cs.values[(cs.i-1)%len(cs.values)]
+}
+
+func TestWithSource(t *testing.T) {
+	b := WithSource(Bernoulli{2}, &cycleSource{values: []float64{.9, .1, .7}})
+	if a := SampleN(4, b); !reflect.DeepEqual(a, Vector{2, -2, 2, 2}) {
+		t.Error("Bernoulli didn't draw from its Source.", a.String())
+	}
+
+	su := WithSource(SegmentedUniform{1, 2}, &cycleSource{values: []float64{.75, .25}})
+	if a := SampleN(2, su); !reflect.DeepEqual(a, Vector{1.5, -1.5}) {
+		t.Error("SegmentedUniform didn't draw from its Source.", a.String())
+	}
+
+	st := WithSource(SymmetricTriangular{1, 3}, &cycleSource{values: []float64{.5, .5, .25}})
+	if a := SampleN(1, st); !reflect.DeepEqual(a, Vector{-2}) {
+		t.Error("SymmetricTriangular didn't draw from its Source.", a.String())
+	}
+
+	seeded := WithSource(Bernoulli{1}, rand.New(rand.NewSource(3)))
+	again := WithSource(Bernoulli{1}, rand.New(rand.NewSource(3)))
+	if a, b := SampleN(10, seeded), SampleN(10, again); !reflect.DeepEqual(a, b) {
+		t.Error("A seeded *rand.Rand Source wasn't reproducible.", a.String(), b.String())
+	}
+}
+
+func TestSPSALastGradientCosine(t *testing.T) {
+	var cosines OnlineStats
+	spsa := &SPSA{
+		L:     func(v Vector) float64 { return v.Dot(v) },
+		C:     NoConstraints,
+		Theta: Vector{10, 3},
+		Ak:    StandardAk(.001, 10, .602),
+		Ck:    StandardCk(.1, .101),
+		Delta: Bernoulli{1},
+	}
+	spsa.AfterUpdate = func(round int, theta Vector) Vector {
+		if round == 1 && spsa.LastGradientCosine() != 0 {
+			t.Error("LastGradientCosine wasn't 0 after the first round.", spsa.LastGradientCosine())
+		} else if round > 1 {
+			cosines.Add(spsa.LastGradientCosine())
+		}
+		return theta
+	}
+	spsa.Run(200)
+
+	if cosines.Mean() <= .25 {
+		t.Error("Successive gradients on a smooth convex loss weren't positively correlated.", cosines.Mean())
+	}
+}
+
+func TestSPSARawDifference(t *testing.T) {
+	spsa := &SPSA{
+		L:     AbsoluteSum,
+		C:     NoConstraints,
+		Theta: Vector{1, 2},
+		Ck:    SliceGain([]float64{.1}),
+		Delta: NewDeterministicBernoulli(1, 1, -1),
+	}
+	grad := spsa.EstimateGradient()
+
+	want := AbsoluteSum(Vector{1, 2}.Add(Vector{.1, -.1})) - AbsoluteSum(Vector{1, 2}.Subtract(Vector{.1, -.1}))
+	if spsa.RawDifference() != want {
+		t.Error("RawDifference isn't the difference of the perturbed losses.", spsa.RawDifference(), want)
+	} else if grad[0] != want/(2*.1) || grad[1] != want/(2*-.1) {
+		t.Error("The gradient isn't the raw difference over 2 * ck * delta.", grad.String())
+	}
+}
+
+func TestSPSATrace(t *testing.T) {
+	traces := make(chan RoundTrace, 10)
+	spsa := &SPSA{
+		L:     AbsoluteSum,
+		C:     NoConstraints,
+		Theta: Vector{1, 1, 1},
+		Ak:    StandardAk(.1, 10, .602),
+		Ck:    StandardCk(.1, .101),
+		Delta: Bernoulli{1},
+		Trace: traces,
+	}
+	spsa.Run(10)
+
+	if len(traces) != 10 {
+		t.Fatal("A 10-round run didn't yield 10 traces.", len(traces))
+	}
+	for round := 1; round <= 10; round++ {
+		tr := <-traces
+		if tr.Round != round {
+			t.Error("The trace isn't for the right round.", tr.Round, round)
+		}
+		for i, d := range tr.Delta {
+			if math.Abs(d) != tr.Ck {
+				t.Error("The traced delta isn't scaled by ck.", tr.Delta.String(), tr.Ck)
+			} else if tr.GradEstimate[i] != (tr.Fpos-tr.Fneg)/(2*d) {
+				t.Error("The traced gradient isn't consistent with the losses and delta.", tr.GradEstimate.String())
+			}
+		}
+	}
+}
+
+func TestRecorderReplayer(t *testing.T) {
+	newSPSA := func(delta PerturbationDistribution) *SPSA {
+		return &SPSA{
+			L:     AbsoluteSum,
+			C:     NoConstraints,
+			Theta: Vector{1, 1, 1, 1, 1},
+			Ak:    StandardAk(1, 100, .602),
+			Ck:    StandardCk(.1, .101),
+			Delta: delta,
+		}
+	}
+
+	recorder := &Recorder{D: SegmentedUniform{.5, 1.5}}
+	recorded := newSPSA(recorder).Run(100)
+
+	draws, err := ParseCSV(recorder.Draws.MarshalCSV())
+	if err != nil {
+		t.Fatal("Recorded draws didn't round-trip through CSV.", err)
+	}
+	replayed := newSPSA(&Replayer{Draws: draws}).Run(100)
+
+	if !reflect.DeepEqual(recorded, replayed) {
+		t.Error("Replayed run didn't reproduce the recorded run.", recorded.String(), replayed.String())
+	}
+}
+
 func TestSegmentedUniform(t *testing.T) {
 	testPerturbationDistribution(t, SegmentedUniform{.5, 1.5})
 }
 
-func testPerturbationDistribution(t *testing.T, p PerturbationDistribution) {
-	var X, Xinv, Xsq float64 // Accumulators
-	n, big := 1000, float64(100)
+func TestSymmetricTriangular(t *testing.T) {
+	st := SymmetricTriangular{.5, 1.5}
+	testPerturbationDistribution(t, st)
 
-	data := SampleN(n, p)
+	for _, d := range SampleN(1000, st) {
+		if math.Abs(d) < .5 || math.Abs(d) > 1.5 {
+			t.Error("SymmetricTriangular sample is outside its support.", d)
+		}
+	}
+}
 
-	for _, d := range data {
-		X += d
-		Xinv += 1 / math.Abs(d)
-		Xsq += math.Pow(d, 2)
+func TestSampleSegmentedUniform(t *testing.T) {
+	su := []SegmentedUniform{{.5, 1}, {2, 3}, {10, 20}}
+	for k := 0; k < 1000; k++ {
+		for i, d := range SampleSegmentedUniform(su) {
+			if math.Abs(d) < su[i].a || math.Abs(d) > su[i].b {
+				t.Error("Per coordinate SegmentedUniform sample is outside its support.", i, d)
+			}
+		}
 	}
+}
 
-	X /= float64(n)
-	Xinv /= float64(n)
-	Xsq /= float64(n)
+func testPerturbationDistribution(t *testing.T, p PerturbationDistribution) {
+	big := float64(100)
+	X, Xsq, Xinv := CheckDistributionMoments(p, 1000)
 
 	if X > big {
 		t.Error("First moment is too large.")
@@ -123,6 +1264,17 @@ func testPerturbationDistribution(t *testing.T, p PerturbationDistribution) {
 	}
 }
 
+func TestCheckDistributionMoments(t *testing.T) {
+	mean, second, inv := CheckDistributionMoments(Bernoulli{2}, 10000)
+	if !near(mean, 0, .1) {
+		t.Error("Bernoulli mean isn't zero.", mean)
+	} else if !near(second, 4, 1e-9) {
+		t.Error("Bernoulli second moment isn't r^2.", second)
+	} else if !near(inv, .5, 1e-9) {
+		t.Error("Bernoulli inverse moment isn't 1/r.", inv)
+	}
+}
+
 //********** Gain Sequence Testing ***************
 
 func TestStandardAk(t *testing.T) {
@@ -133,6 +1285,34 @@ func TestStandardCk(t *testing.T) {
 	testGainSequence(t, StandardCk(rand.Float64()*100, rand.Float64()))
 }
 
+func TestStandardAkVector(t *testing.T) {
+	a := Vector{1, 10}
+	g, s := StandardAkVector(a, 5, .602), StandardAk(1, 5, .602)
+	for i := 0; i < 10; i++ {
+		ak, want := <-g, <-s
+		if !near(ak[0], want, 1e-12) || !near(ak[1], 10*want, 1e-12) {
+			t.Error("StandardAkVector didn't scale each coordinate's standard gain.", ak.String(), want)
+		}
+	}
+}
+
+func TestBurnInAk(t *testing.T) {
+	g := BurnInAk(1, 10, .602, 5)
+	first := <-g
+	if first != 1/math.Pow(11, .602) {
+		t.Error("BurnInAk didn't start at the first standard value.", first)
+	}
+	for i := 1; i < 5; i++ {
+		if cur := <-g; cur != first {
+			t.Error("BurnInAk didn't hold the gain during the burn-in.", i, cur)
+		}
+	}
+	if cur := <-g; cur >= first {
+		t.Error("BurnInAk didn't decay after the burn-in.", cur)
+	}
+	testGainSequence(t, g)
+}
+
 func testGainSequence(t *testing.T, g GainSequence) {
 	last := <-g
 	for i := 0; i < 100; i++ {
@@ -144,3 +1324,90 @@ func testGainSequence(t *testing.T, g GainSequence) {
 		}
 	}
 }
+
+func TestSliceGain(t *testing.T) {
+	values := []float64{.5, .4, .3}
+	g := SliceGain(values)
+
+	for i, v := range values {
+		if cur := <-g; cur != v {
+			t.Error("SliceGain didn't emit the slice in order.", i, cur, v)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if cur := <-g; cur != .3 {
+			t.Error("SliceGain didn't hold the last value after exhaustion.", cur)
+		}
+	}
+}
+
+func TestSPSACkFloor(t *testing.T) {
+	spsa := &SPSA{
+		L:       AbsoluteSum,
+		C:       NoConstraints,
+		Theta:   Vector{1, 1},
+		Ak:      StandardAk(.01, 100, 1),
+		Ck:      StandardCk(.1, 1),
+		Delta:   Bernoulli{1},
+		CkFloor: .01,
+	}
+	spsa.AfterUpdate = func(round int, theta Vector) Vector {
+		if spsa.LastCk < spsa.CkFloor {
+			t.Fatal("SPSA emitted a ck below CkFloor.", round, spsa.LastCk)
+		}
+		return theta
+	}
+	spsa.Run(1000)
+
+	if spsa.LastCk != spsa.CkFloor {
+		t.Error("SPSA ck didn't settle at CkFloor in a long run.", spsa.LastCk)
+	}
+}
+
+func TestSPSAFreezeSchedule(t *testing.T) {
+	spsa := &SPSA{
+		L:              AbsoluteSum,
+		C:              NoConstraints,
+		Theta:          Vector{1, 1},
+		Ak:             StandardAk(.1, 10, .602),
+		Ck:             StandardCk(.1, .101),
+		Delta:          Bernoulli{1},
+		FreezeSchedule: FreezeSchedule{0: {false, true}, 50: nil},
+	}
+	var moved int
+	spsa.AfterUpdate = func(round int, theta Vector) Vector {
+		if theta[1] != 1 && moved == 0 {
+			moved = round
+		}
+		return theta
+	}
+	spsa.Run(100)
+
+	if moved <= 50 {
+		t.Error("FreezeSchedule didn't freeze coordinate 1 for the first 50 rounds.", moved)
+	} else if spsa.Theta[1] > .9 {
+		t.Error("FreezeSchedule didn't unfreeze coordinate 1 after round 50.", spsa.Theta.String())
+	} else if spsa.Theta[0] == 1 {
+		t.Error("FreezeSchedule froze coordinate 0.")
+	}
+}
+
+func TestSPSARunWithGradient(t *testing.T) {
+	spsa := &SPSA{
+		L:     AbsoluteSum,
+		C:     NoConstraints,
+		Theta: Vector{5, 5, 5},
+		Ak:    StandardAk(.1, 10, .602),
+		Ck:    StandardCk(.1, .101),
+		Delta: Bernoulli{1},
+	}
+	theta, grad := spsa.RunWithGradient(10)
+
+	if !reflect.DeepEqual(theta, spsa.Theta) {
+		t.Error("RunWithGradient didn't return the current theta.", theta.String())
+	} else if len(grad) != len(theta) {
+		t.Error("RunWithGradient returned a gradient of the wrong dimension.", grad.String())
+	} else if grad.Dot(Vector{1, 1, 1}) <= 0 {
+		t.Error("RunWithGradient returned a gradient that doesn't point uphill.", grad.String())
+	}
+}
@@ -0,0 +1,72 @@
+package spsa
+
+//********** Online Statistics ***********
+
+// Running mean and variance of a stream of values using Welford's algorithm,
+// which is numerically stable and needs only constant memory.
+// The zero value is ready to use.
+type OnlineStats struct {
+	n        int
+	mean, m2 float64
+}
+
+// Add a value to the running statistics.
+func (st *OnlineStats) Add(x float64) {
+	st.n++
+	d := x - st.mean
+	st.mean += d / float64(st.n)
+	st.m2 += d * (x - st.mean)
+}
+
+// Number of values added
+func (st *OnlineStats) N() int {
+	return st.n
+}
+
+// Mean of the values added
+func (st *OnlineStats) Mean() float64 {
+	return st.mean
+}
+
+// Sample variance of the values added. It is 0 until at least two values are added.
+func (st *OnlineStats) Var() float64 {
+	if st.n < 2 {
+		return 0
+	}
+	return st.m2 / float64(st.n-1)
+}
+
+const defaultQuantileWindow = 1000
+
+// Quantiles of the most recent values of a stream, kept in a bounded window.
+// Window is the number of values kept; zero keeps the last 1000.
+// The zero value is ready to use.
+type QuantileTracker struct {
+	Window int
+	buf    Vector
+	next   int
+}
+
+// Add a value to the window, replacing the oldest one if it is full.
+func (qt *QuantileTracker) Add(x float64) {
+	if qt.Window <= 0 {
+		qt.Window = defaultQuantileWindow
+	}
+	if len(qt.buf) < qt.Window {
+		qt.buf = append(qt.buf, x)
+		return
+	}
+	qt.buf[qt.next] = x
+	qt.next = (qt.next + 1) % qt.Window
+}
+
+// Number of values in the window
+func (qt *QuantileTracker) N() int {
+	return len(qt.buf)
+}
+
+// The q-th quantile (0 <= q <= 1) of the values in the window, linearly
+// interpolated between order statistics. It is NaN if no values were added.
+func (qt *QuantileTracker) Quantile(q float64) float64 {
+	return qt.buf.Quantile(q)
+}
@@ -0,0 +1,86 @@
+package spsa
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestOnlineStats(t *testing.T) {
+	data := Vector{2, 4, 4, 4, 5, 5, 7, 9, 1e3, -3.5}
+	var st OnlineStats
+	for _, x := range data {
+		st.Add(x)
+	}
+
+	if st.N() != len(data) {
+		t.Error("OnlineStats count isn't correct.", st.N())
+	} else if !near(st.Mean(), data.Mean(), 1e-9) {
+		t.Error("OnlineStats Mean isn't correct.", st.Mean(), data.Mean())
+	} else if !near(st.Var(), data.Var(), 1e-6) {
+		t.Error("OnlineStats Var isn't correct.", st.Var(), data.Var())
+	}
+}
+
+func TestSPSALossStats(t *testing.T) {
+	var losses Vector
+	spsa := &SPSA{
+		L: func(v Vector) float64 {
+			l := AbsoluteSum(v)
+			losses = append(losses, l)
+			return l
+		},
+		C:             NoConstraints,
+		Theta:         Vector{1, 1, 1},
+		Ak:            StandardAk(.1, 10, .602),
+		Ck:            StandardCk(.1, .101),
+		Delta:         Bernoulli{1},
+		LossStats:     &OnlineStats{},
+		LossQuantiles: &QuantileTracker{},
+	}
+	spsa.Run(50)
+
+	if spsa.LossStats.N() != 100 {
+		t.Error("SPSA didn't record every loss evaluation.", spsa.LossStats.N())
+	} else if !near(spsa.LossMean(), losses.Mean(), 1e-9) {
+		t.Error("SPSA LossMean isn't correct.", spsa.LossMean(), losses.Mean())
+	} else if !near(spsa.LossVar(), losses.Var(), 1e-9) {
+		t.Error("SPSA LossVar isn't correct.", spsa.LossVar(), losses.Var())
+	} else if !near(spsa.LossQuantiles.Quantile(.5), losses.Median(), 1e-9) {
+		t.Error("SPSA LossQuantiles median isn't correct.", spsa.LossQuantiles.Quantile(.5), losses.Median())
+	}
+}
+
+func TestQuantileTracker(t *testing.T) {
+	qt := &QuantileTracker{Window: 1000}
+	if !math.IsNaN(qt.Quantile(.5)) {
+		t.Error("QuantileTracker of no values wasn't NaN.")
+	}
+
+	for i := 0; i < 5000; i++ {
+		qt.Add(10 + rand.NormFloat64())
+	}
+
+	if qt.N() != 1000 {
+		t.Error("QuantileTracker didn't bound its window.", qt.N())
+	} else if q := qt.Quantile(.5); !near(q, 10, .15) {
+		t.Error("QuantileTracker median isn't close.", q)
+	} else if q := qt.Quantile(.8413); !near(q, 11, .2) {
+		t.Error("QuantileTracker upper quantile isn't close.", q)
+	}
+
+	small := &QuantileTracker{}
+	for _, x := range []float64{3, 1, 2, 4} {
+		small.Add(x)
+	}
+	if small.Quantile(0) != 1 || small.Quantile(1) != 4 || small.Quantile(.5) != 2.5 {
+		t.Error("QuantileTracker didn't interpolate correctly.", small.Quantile(0), small.Quantile(.5), small.Quantile(1))
+	}
+
+	for i := 0; i < 5000; i++ {
+		small.Add(float64(i))
+	}
+	if small.N() != defaultQuantileWindow {
+		t.Error("QuantileTracker without a Window didn't bound its window.", small.N())
+	}
+}
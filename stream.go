@@ -0,0 +1,62 @@
+package spsa
+
+import (
+	"context"
+)
+
+//********** Streaming Runs ***********
+
+// The progress of a streaming run after one round.
+type Progress struct {
+	Round int     // The 1-based round of this run
+	Theta Vector  // Theta after the round
+	Loss  float64 // Loss at Theta
+	Err   error   // Set on the last progress if the run stopped early
+}
+
+// Validate the SPSA instance and run many rounds of SPSA in a new goroutine,
+// streaming the progress after every round on the returned channel. Like
+// RunDetailed, this costs one extra loss evaluation per round unless TrackLoss
+// is set. The channel is closed when the run completes or stops early. If ctx
+// is cancelled, the run stops between rounds and the last progress sent
+// carries ctx.Err(); a divergence error is reported the same way.
+//
+// A stopped run never holds a value taken from a gain sequence, so the gain
+// sequences stay in step and the SPSA instance can be run again. The instance
+// must not be used until the channel is closed.
+func (spsa *SPSA) RunContextStream(ctx context.Context, rounds int) (<-chan Progress, error) {
+	if err := spsa.Validate(); err != nil {
+		return nil, err
+	}
+
+	// One slot is enough to always deliver the final progress without blocking.
+	out := make(chan Progress, 1)
+	go func() {
+		defer close(out)
+
+		var last Progress
+		err := ctx.Err()
+		if err == nil {
+			err = spsa.run(rounds, func() error {
+				last = Progress{Round: last.Round + 1, Theta: spsa.Theta.Copy(), Loss: spsa.currentLoss()}
+				select {
+				case out <- last:
+				case <-ctx.Done():
+				}
+				return ctx.Err()
+			})
+		}
+		if err == nil {
+			return
+		}
+
+		// Replace the progress the receiver hasn't taken, if any.
+		select {
+		case <-out:
+		default:
+		}
+		last.Theta, last.Err = spsa.Theta.Copy(), err
+		out <- last
+	}()
+	return out, nil
+}
@@ -0,0 +1,79 @@
+package spsa
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRunContextStream(t *testing.T) {
+	spsa := &SPSA{
+		L:     AbsoluteSum,
+		C:     NoConstraints,
+		Theta: Vector{1, 1, 1},
+		Ak:    StandardAk(.1, 10, .602),
+		Ck:    StandardCk(.1, .101),
+		Delta: Bernoulli{1},
+	}
+	progress, err := spsa.RunContextStream(context.Background(), 20)
+	if err != nil {
+		t.Fatal("RunContextStream failed.", err)
+	}
+	var n int
+	for p := range progress {
+		n++
+		if p.Round != n || p.Err != nil || p.Loss != AbsoluteSum(p.Theta) {
+			t.Error("RunContextStream sent the wrong progress.", p)
+		}
+	}
+	if n != 20 {
+		t.Error("RunContextStream didn't send progress every round.", n)
+	}
+
+	if _, err := (&SPSA{}).RunContextStream(context.Background(), 20); err == nil {
+		t.Error("RunContextStream didn't validate the SPSA instance.")
+	}
+}
+
+func TestRunContextStreamCancel(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	spsa := &SPSA{
+		L:     AbsoluteSum,
+		C:     NoConstraints,
+		Theta: Vector{1, 1, 1},
+		Ak:    StandardAk(.1, 10, .602),
+		Ck:    StandardCk(.1, .101),
+		Delta: Bernoulli{1},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	progress, _ := spsa.RunContextStream(ctx, 1000000)
+
+	var last Progress
+	for p := range progress {
+		last = p
+		if p.Round == 10 {
+			cancel()
+		}
+	}
+
+	if last.Round < 10 || last.Round >= 1000000 {
+		t.Error("RunContextStream didn't stop soon after the cancellation.", last.Round)
+	} else if last.Err != context.Canceled {
+		t.Error("RunContextStream didn't surface the context's error.", last.Err)
+	}
+
+	// The gain sequences stay in step, so the instance runs on.
+	k := spsa.k
+	spsa.Run(5)
+	if spsa.k != k+5 {
+		t.Error("SPSA didn't run after a cancelled stream.", spsa.k, k)
+	}
+
+	// Only the two gain sequences remain.
+	time.Sleep(10 * time.Millisecond)
+	if g := runtime.NumGoroutine(); g > goroutines+2 {
+		t.Error("RunContextStream leaked goroutines.", g, goroutines)
+	}
+}
@@ -0,0 +1,132 @@
+package spsa
+
+import (
+	"math"
+)
+
+//********** Semiautomatic Tuning ***********
+
+// Spall's semiautomatic tuning of a: probe the gradient probes times at the
+// current theta and return the first gain a_0 that makes the first step
+// a_0 * ||g|| equal desiredInitialStep on average. For the standard gain
+// sequence, use StandardAk(a_0 * math.Pow(A+1, alpha), A, alpha).
+// Returns 0 if every probed gradient is zero.
+//
+// The probes cost 2 * probes loss evaluations and all use the first ck, which
+// is kept for the first round so the run's ck schedule is unchanged.
+func TuneA(spsa *SPSA, desiredInitialStep float64, probes int) float64 {
+	ck, pk := spsa.peekGains()
+
+	var norm float64
+	for i := 0; i < probes; i++ {
+		norm += spsa.probeDirection(ck, pk).Norm() / float64(probes)
+	}
+	if norm == 0 {
+		return 0
+	}
+	return desiredInitialStep / norm
+}
+
+// Estimate the gradient along one perturbation like estimateDirection, but
+// without the side effects of a round's estimate: nothing is sent on Trace,
+// and RawDifference and the antithetic delta are left unchanged.
+func (spsa *SPSA) probeDirection(ck, pk float64) Vector {
+	p := spsa.probePerturbation(ck, pk)
+	fpos, fneg := spsa.evaluatePair(p.tpos, p.tneg)
+	return spsa.gradientOf(p, ck, fpos, fneg)
+}
+
+// Sample a perturbation like perturb, leaving the antithetic delta unchanged.
+func (spsa *SPSA) probePerturbation(ck, pk float64) perturbation {
+	antithetic := spsa.antithetic
+	p := spsa.perturb(ck, pk)
+	spsa.antithetic = antithetic
+	return p
+}
+
+// Estimate the magnitude of the loss's second derivative around theta by
+// central second differences along probes perturbations drawn like a round's,
+// (L(theta + h u) - 2 L(theta) + L(theta - h u)) / h^2 for unit u and the
+// perturbation's length h, averaged in absolute value. Perturbations of length
+// 0, e.g. with every coordinate Frozen, count as no curvature. As a rule of
+// thumb, steps ak * g are unstable when ak is much larger than 2 / curvature.
+// It costs 2 * probes + 1 loss evaluations and uses the next ck and pk without
+// consuming them.
+func (spsa *SPSA) EstimateCurvature(probes int) float64 {
+	ck, pk := spsa.peekGains()
+	f0 := spsa.evaluate(spsa.Theta)
+
+	var curvature float64
+	for i := 0; i < probes; i++ {
+		p := spsa.probePerturbation(ck, pk)
+		h := p.tpos.Distance(p.tneg) / 2
+		if h == 0 {
+			continue
+		}
+		fpos, fneg := spsa.evaluatePair(p.tpos, p.tneg)
+		curvature += math.Abs(fpos-2*f0+fneg) / (h * h) / float64(probes)
+	}
+	return curvature
+}
+
+// The signal-to-noise ratio of the gradient estimate at the current theta, from
+// samples estimates (at least 2) with the next ck. It is the squared norm of
+// their mean over the summed variances of the mean's components, so a value
+// well above 1 means the estimates agree on a direction, while a value below 1
+// means they are dominated by noise. Estimates that agree exactly give +Inf, or
+// 0 if they are all zero, e.g. at the optimum of a noise-free loss. It costs
+// 2 * samples loss evaluations and doesn't consume ck.
+func (spsa *SPSA) GradientSNR(samples int) float64 {
+	if samples < 2 {
+		samples = 2
+	}
+	ck, pk := spsa.peekGains()
+
+	grads := make([]Vector, samples)
+	for i := range grads {
+		grads[i] = spsa.probeDirection(ck, pk)
+	}
+
+	var signal, noise float64
+	column := make(Vector, samples)
+	for j := range spsa.Theta {
+		for i, g := range grads {
+			column[i] = g[j]
+		}
+		signal += math.Pow(column.Mean(), 2)
+		noise += column.Var() / float64(samples)
+	}
+	if noise == 0 {
+		if signal == 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return signal / noise
+}
+
+// The next ck and pk values, read ahead and kept for nextCk and nextPk so
+// that they are not consumed. pk is ck unless PerturbScale is set.
+func (spsa *SPSA) peekGains() (ck, pk float64) {
+	ck = spsa.peekCk()
+	pk = ck
+	if spsa.PerturbScale != nil {
+		if !spsa.hasPeekedPk {
+			spsa.peekedPk, spsa.hasPeekedPk = <-spsa.PerturbScale, true
+		}
+		pk = spsa.peekedPk
+	}
+	return ck, pk
+}
+
+// The next ck value, read ahead and kept for nextCk so that it is not
+// consumed. LastCk is left unchanged.
+func (spsa *SPSA) peekCk() float64 {
+	if spsa.AdaptCk != nil {
+		return math.Max(spsa.AdaptCk.C, spsa.CkFloor)
+	}
+	if !spsa.hasPeekedCk {
+		spsa.peekedCk, spsa.hasPeekedCk = <-spsa.Ck, true
+	}
+	return math.Max(spsa.peekedCk, spsa.CkFloor)
+}
@@ -0,0 +1,147 @@
+package spsa
+
+import (
+	"math"
+	"runtime"
+	"testing"
+)
+
+func TestTuneA(t *testing.T) {
+	spsa := &SPSA{
+		L:     func(v Vector) float64 { return v.Dot(v) },
+		C:     NoConstraints,
+		Theta: Vector{3},
+		Ck:    StandardCk(.1, .101),
+		Delta: Bernoulli{1},
+	}
+	a0 := TuneA(spsa, .5, 10)
+	spsa.Ak = StandardAk(a0*math.Pow(11, .602), 10, .602)
+
+	theta0 := spsa.Theta.Copy()
+	spsa.Run(1)
+
+	if step := spsa.Theta.Distance(theta0); step < .25 || step > 1 {
+		t.Error("TuneA's first step wasn't near the desired step.", step)
+	} else if spsa.LastCk != .1 {
+		t.Error("TuneA didn't preserve the ck schedule.", spsa.LastCk)
+	}
+}
+
+func TestEstimateCurvature(t *testing.T) {
+	spsa := &SPSA{
+		L:     func(v Vector) float64 { return v.Dot(v) },
+		C:     NoConstraints,
+		Theta: Vector{1.5, -2, .5},
+		Ck:    StandardCk(.1, .101),
+		Delta: Bernoulli{1},
+	}
+
+	if c := spsa.EstimateCurvature(5); !near(c, 2, 1e-6) {
+		t.Error("EstimateCurvature of a quadratic x^2 wasn't near 2.", c)
+	} else if spsa.Evaluations() != 11 {
+		t.Error("EstimateCurvature didn't make 2 * probes + 1 evaluations.", spsa.Evaluations())
+	} else if spsa.LastCk != 0 {
+		t.Error("EstimateCurvature changed LastCk.", spsa.LastCk)
+	} else if ck := spsa.nextCk(); ck != .1 {
+		t.Error("EstimateCurvature consumed a ck value.", ck)
+	}
+}
+
+func TestEstimateCurvaturePerturbations(t *testing.T) {
+	// Only the first coordinate is free, and it is the less curved one
+	L := func(v Vector) float64 { return v[0]*v[0] + 100*v[1]*v[1] }
+
+	sampled := &SPSA{
+		L:                L,
+		C:                NoConstraints,
+		Theta:            Vector{1, 1},
+		Ck:               StandardCk(.1, .101),
+		DirectionSampler: NewOrthonormalDirections(1),
+		Frozen:           []bool{false, true},
+	}
+	if c := sampled.EstimateCurvature(5); !near(c, 2, 1e-6) {
+		t.Error("EstimateCurvature with a DirectionSampler ignored Frozen.", c)
+	}
+
+	delta := &SPSA{
+		L:            L,
+		C:            NoConstraints,
+		Theta:        Vector{1, 1},
+		Ck:           StandardCk(.1, .101),
+		PerturbScale: SliceGain([]float64{.01}),
+		Delta:        Bernoulli{1},
+		Frozen:       []bool{false, true},
+	}
+	if c := delta.EstimateCurvature(5); !near(c, 2, 1e-6) {
+		t.Error("EstimateCurvature with a Delta ignored Frozen.", c)
+	} else if pk := delta.nextPk(0); pk != .01 {
+		t.Error("EstimateCurvature consumed a pk value.", pk)
+	}
+}
+
+func TestProbesHaveNoSideEffects(t *testing.T) {
+	traces := make(chan RoundTrace, 100)
+	spsa := &SPSA{
+		L:     func(v Vector) float64 { return v.Dot(v) },
+		C:     NoConstraints,
+		Theta: Vector{1, 2},
+		Ak:    StandardAk(.1, 10, .602),
+		Ck:    StandardCk(.1, .101),
+		Delta: Bernoulli{1},
+		Trace: traces,
+	}
+	spsa.Run(1)
+	diff, ck := spsa.RawDifference(), spsa.LastCk
+
+	TuneA(spsa, .5, 3)
+	spsa.EstimateCurvature(3)
+	spsa.GradientSNR(3)
+
+	if len(traces) != 1 {
+		t.Error("The probes sent gradient estimates on Trace.", len(traces))
+	} else if spsa.RawDifference() != diff || spsa.LastCk != ck {
+		t.Error("The probes changed RawDifference or LastCk.", spsa.RawDifference(), spsa.LastCk)
+	}
+}
+
+func TestPeekGainsKeepsOneValue(t *testing.T) {
+	spsa := &SPSA{
+		L:            func(v Vector) float64 { return v.Dot(v) },
+		C:            NoConstraints,
+		Theta:        Vector{1, 2},
+		Ck:           SliceGain([]float64{.1, .2}),
+		PerturbScale: SliceGain([]float64{.05, .1}),
+		Delta:        Bernoulli{1},
+	}
+
+	goroutines := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		spsa.GradientSNR(2)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Error("Peeking at the gains started goroutines.", goroutines, n)
+	}
+	if ck, pk := spsa.nextCk(), spsa.nextPk(0); ck != .1 || pk != .05 {
+		t.Error("Peeking at the gains consumed them.", ck, pk)
+	} else if ck, pk := spsa.nextCk(), spsa.nextPk(0); ck != .2 || pk != .1 {
+		t.Error("The gains didn't continue after the peeked values.", ck, pk)
+	}
+}
+
+func TestGradientSNRDegenerate(t *testing.T) {
+	spsa := &SPSA{
+		L:     func(v Vector) float64 { return v.Dot(v) },
+		C:     NoConstraints,
+		Theta: Vector{0, 0},
+		Ck:    StandardCk(.1, .101),
+		Delta: Bernoulli{1},
+	}
+
+	if snr := spsa.GradientSNR(1); snr != 0 {
+		t.Error("GradientSNR at the optimum of a noise-free loss isn't 0.", snr)
+	}
+	spsa.Theta = Vector{1}
+	if snr := spsa.GradientSNR(1); !math.IsInf(snr, 1) {
+		t.Error("GradientSNR of agreeing estimates isn't +Inf.", snr)
+	}
+}
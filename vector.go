@@ -3,6 +3,9 @@ package spsa
 import (
 	"fmt"
 	"math"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 // A simple real vector type for better readability. All operations are out-of-place.
@@ -17,6 +20,20 @@ func (a Vector) Copy() Vector {
 	return b
 }
 
+// Concatenate vs into a new vector.
+func Concat(vs ...Vector) Vector {
+	var c Vector
+	for _, v := range vs {
+		c = append(c, v...)
+	}
+	return c
+}
+
+// Sub vector of a from start up to but not including end. (out of place)
+func (a Vector) Sub(start, end int) Vector {
+	return a[start:end].Copy()
+}
+
 // Scale a by s. Returns the new vector. (out of place)
 func (a Vector) Scale(s float64) Vector {
 	b := a.Copy()
@@ -44,6 +61,183 @@ func (a Vector) Subtract(b Vector) Vector {
 	return c
 }
 
+// Compute a + s*b in a single pass. (out of place)
+func (a Vector) AddScaled(b Vector, s float64) Vector {
+	return a.Copy().AddScaledInPlace(b, s)
+}
+
+// Compute a + s*b into a and return it. (in place)
+func (a Vector) AddScaledInPlace(b Vector, s float64) Vector {
+	for i, v := range b {
+		a[i] += s * v
+	}
+	return a
+}
+
+// Linearly interpolate between a and b, (1-t)*a + t*b, which is a at t = 0 and
+// b at t = 1. (out of place)
+func (a Vector) Lerp(b Vector, t float64) Vector {
+	c := make(Vector, len(a))
+	for i, v := range a {
+		c[i] = (1-t)*v + t*b[i]
+	}
+	return c
+}
+
+// Sign of each element of a, as -1, 0 or 1. (out of place)
+func (a Vector) Sign() Vector {
+	b := make(Vector, len(a))
+	for i, v := range a {
+		switch {
+		case v > 0:
+			b[i] = 1
+		case v < 0:
+			b[i] = -1
+		}
+	}
+	return b
+}
+
+// Whether a and b have the same length and elements.
+func (a Vector) equal(b Vector) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, v := range a {
+		if v != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Whether a and b have the same length and each pair of elements is at most
+// maxULP units in the last place apart, i.e. there are at most maxULP - 1
+// floats between them. This tolerates rounding differences independently of
+// magnitude. Zeros of either sign are equal, and NaNs are never equal.
+func (a Vector) EqualULP(b Vector, maxULP uint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, v := range a {
+		if math.IsNaN(v) || math.IsNaN(b[i]) {
+			return false
+		}
+		x, y := ordered(v), ordered(b[i])
+		if x > y {
+			x, y = y, x
+		}
+		if uint64(y-x) > uint64(maxULP) {
+			return false
+		}
+	}
+	return true
+}
+
+// Map the bits of v to an integer with the same order as the floats, with
+// adjacent floats adjacent.
+func ordered(v float64) int64 {
+	i := int64(math.Float64bits(v))
+	if i < 0 {
+		i = math.MinInt64 - i
+	}
+	return i
+}
+
+// Dot product of a and b
+func (a Vector) Dot(b Vector) (d float64) {
+	for i, v := range a {
+		d += v * b[i]
+	}
+	return d
+}
+
+// Euclidean norm of a
+func (a Vector) Norm() float64 {
+	return math.Sqrt(a.Dot(a))
+}
+
+// Euclidean distance between a and b. It panics if their lengths differ.
+func (a Vector) Distance(b Vector) float64 {
+	return math.Sqrt(a.DistanceSquared(b))
+}
+
+// Squared euclidean distance between a and b, which avoids the square root when
+// only comparing distances. It panics if their lengths differ.
+func (a Vector) DistanceSquared(b Vector) (d float64) {
+	if len(a) != len(b) {
+		panic(fmt.Sprintf("spsa: distance between vectors of length %d and %d", len(a), len(b)))
+	}
+	for i, v := range a {
+		d += (v - b[i]) * (v - b[i])
+	}
+	return d
+}
+
+// Unit vector in the direction of a. The zero vector normalizes to a zero
+// vector rather than NaNs. (out of place)
+func (a Vector) Normalize() Vector {
+	b := make(Vector, len(a))
+	if n := a.Norm(); n > 0 {
+		for i, v := range a {
+			b[i] = v / n
+		}
+	}
+	return b
+}
+
+// Softmax of a, exp(a_i) / sum_j exp(a_j), turning logits into probabilities.
+// The maximum is subtracted first so large inputs don't overflow. (out of place)
+func (a Vector) Softmax() Vector {
+	b := make(Vector, len(a))
+	m, _ := a.Max()
+	var sum float64
+	for i, v := range a {
+		b[i] = math.Exp(v - m)
+		sum += b[i]
+	}
+	for i := range b {
+		b[i] /= sum
+	}
+	return b
+}
+
+// Round each element of a to the nearest multiple of step. A non-positive step
+// leaves the values unchanged. (out of place)
+func (a Vector) Quantize(step float64) Vector {
+	b := a.Copy()
+	if step > 0 {
+		for i, v := range a {
+			b[i] = math.Floor(v/step+.5) * step
+		}
+	}
+	return b
+}
+
+// Round each element of a to the nearest integer, with halves away from zero. (out of place)
+func (a Vector) Round() Vector {
+	return a.apply(math.Round)
+}
+
+// Round each element of a down to an integer. (out of place)
+func (a Vector) Floor() Vector {
+	return a.apply(math.Floor)
+}
+
+// Round each element of a up to an integer. (out of place)
+func (a Vector) Ceil() Vector {
+	return a.apply(math.Ceil)
+}
+
+// Apply f to each element of a. (out of place)
+func (a Vector) apply(f func(float64) float64) Vector {
+	b := make(Vector, len(a))
+	for i, v := range a {
+		b[i] = f(v)
+	}
+	return b
+}
+
 // Sum a
 func (a Vector) Sum() (s float64) {
 	for _, v := range a {
@@ -52,11 +246,122 @@ func (a Vector) Sum() (s float64) {
 	return s
 }
 
+// Fold the elements of a into an accumulator starting from init, in order.
+// This generalizes Sum, e.g. a.Reduce(1, func(p, x float64) float64 { return p * x }) is the product.
+func (a Vector) Reduce(init float64, f func(acc, x float64) float64) float64 {
+	acc := init
+	for _, v := range a {
+		acc = f(acc, v)
+	}
+	return acc
+}
+
+// Cumulative sums of a. (out of place)
+func (a Vector) CumSum() Vector {
+	b := make(Vector, len(a))
+	var s float64
+	for i, v := range a {
+		s += v
+		b[i] = s
+	}
+	return b
+}
+
+// Running means of a, the mean of each prefix of a. (out of place)
+func (a Vector) RunningMean() Vector {
+	b := a.CumSum()
+	for i := range b {
+		b[i] /= float64(i + 1)
+	}
+	return b
+}
+
 // Mean of a
 func (a Vector) Mean() (m float64) {
 	return a.Sum() / float64(len(a))
 }
 
+// Mean of a weighted by w. The weights need not sum to one.
+func (a Vector) WeightedMean(w Vector) (m float64) {
+	for i, v := range a {
+		m += v * w[i]
+	}
+	return m / w.Sum()
+}
+
+// Median of a. For an even length, the average of the two middle elements. (out of place)
+func (a Vector) Median() float64 {
+	b := a.Copy()
+	sort.Float64s(b)
+	n := len(b)
+	if n%2 == 0 {
+		return (b[n/2-1] + b[n/2]) / 2
+	}
+	return b[n/2]
+}
+
+// The q-th quantile (0 <= q <= 1) of the elements of a, linearly interpolated
+// between order statistics. An empty vector returns NaN.
+func (a Vector) Quantile(q float64) float64 {
+	if len(a) == 0 {
+		return math.NaN()
+	}
+	b := a.Copy()
+	sort.Float64s(b)
+
+	pos := q * float64(len(b)-1)
+	lo := int(math.Floor(pos))
+	if lo >= len(b)-1 {
+		return b[len(b)-1]
+	} else if lo < 0 {
+		return b[0]
+	}
+	return b[lo] + (pos-float64(lo))*(b[lo+1]-b[lo])
+}
+
+// Clip each element of a into the band between the lo-th and hi-th quantiles
+// of the same coordinate over history, e.g. past gradient estimates. This
+// rejects outlying components without touching the others, unlike clipping by
+// a global norm. An empty history leaves a unchanged. (out of place)
+func (a Vector) ClipPercentile(history []Vector, lo, hi float64) Vector {
+	b := a.Copy()
+	if len(history) == 0 {
+		return b
+	}
+	column := make(Vector, len(history))
+	for i := range b {
+		for j, h := range history {
+			column[j] = h[i]
+		}
+		b[i] = math.Min(math.Max(b[i], column.Quantile(lo)), column.Quantile(hi))
+	}
+	return b
+}
+
+// Minimum of a and its index. On ties the first index wins.
+// An empty vector returns NaN and -1.
+func (a Vector) Min() (m float64, idx int) {
+	m, idx = math.NaN(), -1
+	for i, v := range a {
+		if idx < 0 || v < m {
+			m, idx = v, i
+		}
+	}
+	return m, idx
+}
+
+// Maximum of a and its index. On ties the first index wins.
+// An empty vector returns NaN and -1.
+func (a Vector) Max() (m float64, idx int) {
+	m, idx = math.NaN(), -1
+	for i, v := range a {
+		if idx < 0 || v > m {
+			m, idx = v, i
+		}
+	}
+	return m, idx
+}
+
 // Variance of a
 func (a Vector) Var() (x float64) {
 	m := a.Mean()
@@ -67,6 +372,11 @@ func (a Vector) Var() (x float64) {
 	return x
 }
 
+// Standard deviation of a, the square root of Var
+func (a Vector) Std() float64 {
+	return math.Sqrt(a.Var())
+}
+
 // Mean squared of a
 func (a Vector) MeanSquare() (x float64) {
 	for _, v := range a {
@@ -85,3 +395,45 @@ func (a Vector) String() (s string) {
 	}
 	return "[" + s + "]"
 }
+
+// Labeled form like {lr: 0.01, reg: 0.001}, pairing each element with the label
+// of the same index. If there are fewer labels than elements, the unlabeled
+// elements are labeled by their index; extra labels are ignored.
+func (a Vector) StringLabeled(labels []string) string {
+	s := make([]string, len(a))
+	for i, v := range a {
+		label := strconv.Itoa(i)
+		if i < len(labels) {
+			label = labels[i]
+		}
+		s[i] = label + ": " + strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return "{" + strings.Join(s, ", ") + "}"
+}
+
+// Comma separated form with full precision. It round-trips through ParseCSV.
+func (a Vector) MarshalCSV() string {
+	s := make([]string, len(a))
+	for i, v := range a {
+		s[i] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return strings.Join(s, ",")
+}
+
+// Parse a vector from comma separated values. Whitespace around values is ignored.
+func ParseCSV(s string) (Vector, error) {
+	if strings.TrimSpace(s) == "" {
+		return Vector{}, nil
+	}
+
+	fields := strings.Split(s, ",")
+	a := make(Vector, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil {
+			return nil, fmt.Errorf("spsa: invalid CSV value %q at position %d", f, i)
+		}
+		a[i] = v
+	}
+	return a, nil
+}
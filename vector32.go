@@ -0,0 +1,79 @@
+package spsa
+
+import (
+	"math"
+)
+
+//********** Float32 Vectors ***********
+
+// A single precision vector, for very high dimensional problems where the
+// memory and cache pressure of theta and the perturbed points matter. Loss
+// values, gains and accumulations stay in double precision.
+type Vector32 []float32
+
+// A loss function of a single precision parameter vector.
+type LossFunction32 func(Vector32) float64
+
+// Convert a to single precision. (out of place)
+func (a Vector) Float32() Vector32 {
+	b := make(Vector32, len(a))
+	for i, v := range a {
+		b[i] = float32(v)
+	}
+	return b
+}
+
+// Convert a to double precision. (out of place)
+func (a Vector32) Float64() Vector {
+	b := make(Vector, len(a))
+	for i, v := range a {
+		b[i] = float64(v)
+	}
+	return b
+}
+
+// Copy a vector (out of place)
+func (a Vector32) Copy() Vector32 {
+	b := make(Vector32, len(a))
+	copy(b, a)
+	return b
+}
+
+// Dot product of a and b, accumulated in double precision
+func (a Vector32) Dot(b Vector32) (d float64) {
+	for i, v := range a {
+		d += float64(v) * float64(b[i])
+	}
+	return d
+}
+
+// Euclidean norm of a
+func (a Vector32) Norm() float64 {
+	return math.Sqrt(a.Dot(a))
+}
+
+// A helper function like OptimizeWith for single precision parameter vectors.
+// It runs the standard SPSA recursion without any of the options of the SPSA
+// struct, and reuses its buffers so that it only keeps theta, the perturbation
+// and the two perturbed points in memory.
+func Optimize32(L LossFunction32, theta0 Vector32, n int, a, c float64, delta PerturbationDistribution) Vector32 {
+	Ak, Ck := StandardAk(a, aBias(n), .602), StandardCk(c, .101)
+
+	theta := theta0.Copy()
+	d := make(Vector32, len(theta))
+	tpos, tneg := make(Vector32, len(theta)), make(Vector32, len(theta))
+
+	for k := 0; k < n; k++ {
+		ak, ck := <-Ak, <-Ck
+		for i := range d {
+			d[i] = float32(ck * delta.Sample())
+			tpos[i], tneg[i] = theta[i]+d[i], theta[i]-d[i]
+		}
+
+		diff := L(tpos) - L(tneg)
+		for i, di := range d {
+			theta[i] -= float32(ak * diff / (2 * float64(di)))
+		}
+	}
+	return theta
+}
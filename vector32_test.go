@@ -0,0 +1,37 @@
+package spsa
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestVector32(t *testing.T) {
+	a := Vector{1, -2.5, 4}
+	b := a.Float32()
+
+	if !reflect.DeepEqual(b.Float64(), a) {
+		t.Error("Vector32 didn't round-trip exactly representable values.", b)
+	} else if b.Norm() != a.Norm() || b.Dot(b.Copy()) != a.Dot(a) {
+		t.Error("Vector32 Norm or Dot isn't correct.", b.Norm(), a.Norm())
+	}
+}
+
+func TestOptimize32(t *testing.T) {
+	theta0 := Vector{1, 2, 3, 4, 5}
+	L := func(v Vector) float64 { return v.Dot(v) }
+	L32 := func(v Vector32) float64 { return v.Dot(v) }
+
+	want := OptimizeWith(L, theta0, 200, .1, .1, NewSeededBernoulli(1, 1))
+	got := Optimize32(L32, theta0.Float32(), 200, .1, .1, NewSeededBernoulli(1, 1))
+
+	for i, v := range got.Float64() {
+		if math.Abs(v-want[i]) > 1e-5*(1+math.Abs(want[i])) {
+			t.Error("Optimize32 didn't match the double precision run within float32 tolerance.", got, want.String())
+			break
+		}
+	}
+	if L(want) > L(theta0)/100 {
+		t.Error("SPSA didn't optimize the quadratic very well...", want.String())
+	}
+}
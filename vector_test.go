@@ -1,11 +1,13 @@
 package spsa
 
 import (
+	"encoding/json"
+	"math"
 	"reflect"
 	"testing"
 )
 
-func close(a, b, eps float64) bool {
+func closeTo(a, b, eps float64) bool {
 	return a-b < eps
 }
 
@@ -21,6 +23,25 @@ func TestCopy(t *testing.T) {
 	}
 }
 
+func TestConcat(t *testing.T) {
+	c := Concat(Vector{1, 2}, Vector{}, Vector{3, 4, 5})
+	if !reflect.DeepEqual(c, Vector{1, 2, 3, 4, 5}) {
+		t.Error("Concat did not operate correctly.", c.String())
+	}
+}
+
+func TestSub(t *testing.T) {
+	a := Vector{1, 2, 3, 4, 5}
+	b := a.Sub(1, 3)
+	b[0] = 10
+
+	if !reflect.DeepEqual(a, Vector{1, 2, 3, 4, 5}) {
+		t.Error("Sub aliased its parent vector.")
+	} else if !reflect.DeepEqual(b, Vector{10, 3}) {
+		t.Error("Sub did not operate correctly.")
+	}
+}
+
 func TestScale(t *testing.T) {
 	a := Vector{1, 2, 3, 4, 5}
 	b := a.Scale(5)
@@ -46,6 +67,35 @@ func TestAdd(t *testing.T) {
 	}
 }
 
+func TestAddScaled(t *testing.T) {
+	a := Vector{1, 2, 3, 4, 5}
+	b := Vector{5, 4, 3, 2, 1}
+
+	if c := a.AddScaled(b, -1); !reflect.DeepEqual(c, a.Subtract(b)) {
+		t.Error("AddScaled by -1 did not match Subtract.", c)
+	} else if !reflect.DeepEqual(a, Vector{1, 2, 3, 4, 5}) {
+		t.Error("AddScaled did not run out of place.")
+	}
+
+	if c := a.AddScaledInPlace(b, 2); !reflect.DeepEqual(a, Vector{11, 10, 9, 8, 7}) || !reflect.DeepEqual(c, a) {
+		t.Error("AddScaledInPlace did not operate in place.", a, c)
+	}
+}
+
+func TestLerp(t *testing.T) {
+	a, b := Vector{1, -2, 3}, Vector{3, 2, .3}
+
+	if c := a.Lerp(b, 0); !reflect.DeepEqual(c, a) {
+		t.Error("Lerp at 0 isn't a.", c.String())
+	} else if c := a.Lerp(b, 1); !reflect.DeepEqual(c, b) {
+		t.Error("Lerp at 1 isn't b.", c.String())
+	} else if c := a.Lerp(b, .5); !reflect.DeepEqual(c, Vector{2, 0, 1.65}) {
+		t.Error("Lerp at .5 isn't the midpoint.", c.String())
+	} else if !reflect.DeepEqual(a, Vector{1, -2, 3}) {
+		t.Error("Lerp did not run out of place.")
+	}
+}
+
 func TestSubtract(t *testing.T) {
 	a := Vector{1, 2, 3, 4, 5}
 	b := Vector{5, 4, 3, 2, 1}
@@ -60,23 +110,246 @@ func TestSubtract(t *testing.T) {
 	}
 }
 
+func TestSign(t *testing.T) {
+	a := Vector{-2.5, 0, 3}
+	b := a.Sign()
+
+	if !reflect.DeepEqual(a, Vector{-2.5, 0, 3}) {
+		t.Error("Sign did not run out of place.")
+	} else if !reflect.DeepEqual(b, Vector{-1, 0, 1}) {
+		t.Error("Sign did not operate correctly.")
+	}
+}
+
+func TestEqualULP(t *testing.T) {
+	a, b := Vector{.1, .2}, Vector{.8, 2.8}
+	fused, unfused := make(Vector, 2), make(Vector, 2)
+	for i := range a {
+		fused[i] = math.FMA(a[i], b[i], .7)
+		unfused[i] = float64(a[i]*b[i]) + .7
+	}
+
+	if reflect.DeepEqual(fused, unfused) {
+		t.Error("Fused and unfused computations didn't differ.", fused, unfused)
+	} else if !fused.EqualULP(unfused, 1) {
+		t.Error("EqualULP didn't tolerate a difference of one ULP.", fused, unfused)
+	} else if fused.EqualULP(unfused, 0) {
+		t.Error("EqualULP with no ULPs tolerated a difference.")
+	} else if !(Vector{0}).EqualULP(Vector{math.Copysign(0, -1)}, 0) {
+		t.Error("EqualULP didn't equate zeros of either sign.")
+	} else if (Vector{math.NaN()}).EqualULP(Vector{math.NaN()}, 10) {
+		t.Error("EqualULP equated NaNs.")
+	} else if (Vector{1}).EqualULP(Vector{1, 1}, 10) {
+		t.Error("EqualULP equated vectors of different lengths.")
+	}
+}
+
+func TestDot(t *testing.T) {
+	a := Vector{1, 2, 3}
+	if !closeTo(a.Dot(Vector{4, -5, 6}), 12, 0.0001) {
+		t.Error("Vector Dot isn't correct.")
+	}
+}
+
+func TestNorm(t *testing.T) {
+	a := Vector{3, -4}
+	if !closeTo(a.Norm(), 5, 0.0001) {
+		t.Error("Vector Norm isn't correct.")
+	}
+}
+
+func TestRound(t *testing.T) {
+	a := Vector{-2.5, -1.4, -.6, 0, .4, 1.5, 2.7}
+
+	if b := a.Round(); !reflect.DeepEqual(b, Vector{-3, -1, -1, 0, 0, 2, 3}) {
+		t.Error("Vector Round isn't correct.", b)
+	} else if b := a.Floor(); !reflect.DeepEqual(b, Vector{-3, -2, -1, 0, 0, 1, 2}) {
+		t.Error("Vector Floor isn't correct.", b)
+	} else if b := a.Ceil(); !reflect.DeepEqual(b, Vector{-2, -1, 0, 0, 1, 2, 3}) {
+		t.Error("Vector Ceil isn't correct.", b)
+	} else if !reflect.DeepEqual(a, Vector{-2.5, -1.4, -.6, 0, .4, 1.5, 2.7}) {
+		t.Error("Vector rounding did not run out of place.")
+	}
+}
+
+func TestQuantile(t *testing.T) {
+	a := Vector{3, 1, 2, 4}
+	if a.Quantile(0) != 1 || a.Quantile(1) != 4 || a.Quantile(.5) != 2.5 || a.Quantile(1.0/3) != 2 {
+		t.Error("Vector Quantile isn't correct.", a.Quantile(0), a.Quantile(.5), a.Quantile(1))
+	} else if !math.IsNaN(Vector{}.Quantile(.5)) {
+		t.Error("Vector Quantile of an empty vector isn't NaN.")
+	}
+}
+
+func TestClipPercentile(t *testing.T) {
+	history := make([]Vector, 101)
+	for i := range history {
+		x := float64(i-50) / 50
+		history[i] = Vector{x, x, -x}
+	}
+
+	grad := Vector{.5, 100, -.3}
+	if b := grad.ClipPercentile(history, .01, .99); !reflect.DeepEqual(b, Vector{.5, .98, -.3}) {
+		t.Error("ClipPercentile didn't clip only the outlying component.", b)
+	} else if !reflect.DeepEqual(grad, Vector{.5, 100, -.3}) {
+		t.Error("ClipPercentile did not run out of place.")
+	}
+}
+
+func TestDistance(t *testing.T) {
+	a, b := Vector{1, 2, 3}, Vector{4, 6, 3}
+	if a.DistanceSquared(b) != 25 {
+		t.Error("Vector DistanceSquared isn't correct.", a.DistanceSquared(b))
+	} else if a.Distance(b) != 5 || b.Distance(a) != 5 {
+		t.Error("Vector Distance isn't correct.", a.Distance(b))
+	} else if a.Distance(a) != 0 {
+		t.Error("Vector Distance to itself isn't 0.", a.Distance(a))
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Vector Distance didn't panic on a length mismatch.")
+		}
+	}()
+	a.Distance(Vector{1, 2})
+}
+
+func TestNormalize(t *testing.T) {
+	a := Vector{3, -4}
+	b := a.Normalize()
+
+	if !reflect.DeepEqual(a, Vector{3, -4}) {
+		t.Error("Normalize did not run out of place.")
+	} else if !reflect.DeepEqual(b, Vector{.6, -.8}) {
+		t.Error("Normalize did not operate correctly.", b.String())
+	}
+
+	if z := (Vector{0, 0, 0}).Normalize(); !reflect.DeepEqual(z, Vector{0, 0, 0}) {
+		t.Error("Normalize of the zero vector isn't the zero vector.", z.String())
+	}
+}
+
+func TestSoftmax(t *testing.T) {
+	a := Vector{1, 2, 3}
+	b := a.Softmax()
+	if !reflect.DeepEqual(a, Vector{1, 2, 3}) {
+		t.Error("Softmax did not run out of place.")
+	} else if !closeTo(b.Sum(), 1, 1e-12) || !(b[0] < b[1] && b[1] < b[2]) {
+		t.Error("Softmax isn't a probability vector.", b.String())
+	} else if !closeTo(b[2]/b[1], math.E, 1e-9) {
+		t.Error("Softmax ratios aren't correct.", b.String())
+	}
+
+	c := Vector{1000, 1000, -1000}.Softmax()
+	if !closeTo(c.Sum(), 1, 1e-12) || !closeTo(c[0], .5, 1e-12) || c[2] != 0 {
+		t.Error("Softmax overflowed on large inputs.", c.String())
+	}
+}
+
+func TestQuantize(t *testing.T) {
+	a := Vector{.12, -.37, 1.5}
+	b := a.Quantize(.25)
+
+	if !reflect.DeepEqual(a, Vector{.12, -.37, 1.5}) {
+		t.Error("Quantize did not run out of place.")
+	} else if !reflect.DeepEqual(b, Vector{0, -.25, 1.5}) {
+		t.Error("Quantize did not operate correctly.", b.String())
+	} else if !reflect.DeepEqual(a.Quantize(0), a) {
+		t.Error("Quantize with a zero step changed the values.")
+	}
+}
+
 func TestSum(t *testing.T) {
 	a := Vector{1, 2, 3, 4, 5.6}
-	if !close(a.Sum(), 15.6, 0.0001) {
+	if !closeTo(a.Sum(), 15.6, 0.0001) {
 		t.Error("Vector Sum isn't correct.")
 	}
 }
 
+func TestReduce(t *testing.T) {
+	a := Vector{1, -2, 3, 4.5}
+	sumSquares := a.Reduce(0, func(acc, x float64) float64 { return acc + x*x })
+	if !closeTo(sumSquares, a.MeanSquare()*float64(len(a)), 0.0001) {
+		t.Error("Vector Reduce sum of squares isn't correct.", sumSquares)
+	} else if r := (Vector{}).Reduce(7, math.Max); r != 7 {
+		t.Error("Vector Reduce of an empty vector isn't init.", r)
+	}
+}
+
+func TestCumSum(t *testing.T) {
+	a := Vector{1, 2, 3, -4}
+	b := a.CumSum()
+
+	if !reflect.DeepEqual(a, Vector{1, 2, 3, -4}) {
+		t.Error("CumSum did not run out of place.")
+	} else if !reflect.DeepEqual(b, Vector{1, 3, 6, 2}) {
+		t.Error("CumSum did not operate correctly.", b.String())
+	}
+}
+
+func TestRunningMean(t *testing.T) {
+	a := Vector{4, 2, 6, -4}
+	if b := a.RunningMean(); !reflect.DeepEqual(b, Vector{4, 3, 4, 2}) {
+		t.Error("RunningMean did not operate correctly.", b.String())
+	}
+}
+
 func TestMean(t *testing.T) {
 	a := Vector{1.1, 2, 2.9}
-	if !close(a.Mean(), 2.0, 0.0001) {
+	if !closeTo(a.Mean(), 2.0, 0.0001) {
 		t.Error("Vector Mean isn't correct.")
 	}
 }
 
+func TestWeightedMean(t *testing.T) {
+	a := Vector{1, 2, 3}
+	if !closeTo(a.WeightedMean(Vector{1, 1, 2}), 2.25, 0.0001) {
+		t.Error("Vector WeightedMean isn't correct.")
+	}
+}
+
+func TestMedian(t *testing.T) {
+	a := Vector{5, 1, 3}
+	if a.Median() != 3 {
+		t.Error("Vector Median isn't correct.", a.Median())
+	}
+
+	b := Vector{4, 1, 3, 2}
+	if b.Median() != 2.5 {
+		t.Error("Vector Median isn't correct for an even length.", b.Median())
+	} else if !reflect.DeepEqual(b, Vector{4, 1, 3, 2}) {
+		t.Error("Median did not run out of place.")
+	}
+}
+
+func TestMin(t *testing.T) {
+	if m, i := (Vector{3, -1, 2}).Min(); m != -1 || i != 1 {
+		t.Error("Vector Min isn't correct.", m, i)
+	}
+	if m, i := (Vector{3, 1, 2, 1}).Min(); m != 1 || i != 1 {
+		t.Error("Vector Min didn't pick the first index on a tie.", m, i)
+	}
+}
+
+func TestMax(t *testing.T) {
+	if m, i := (Vector{3, 5, 2}).Max(); m != 5 || i != 1 {
+		t.Error("Vector Max isn't correct.", m, i)
+	}
+	if m, i := (Vector{1, 4, 2, 4}).Max(); m != 4 || i != 1 {
+		t.Error("Vector Max didn't pick the first index on a tie.", m, i)
+	}
+}
+
+func TestStd(t *testing.T) {
+	a := Vector{2, 4, 4, 4, 5, 5, 7, 9}
+	if !closeTo(a.Std(), 2.13809, 0.0001) || !closeTo(2.13809, a.Std(), 0.0001) {
+		t.Error("Vector Std isn't correct.", a.Std())
+	}
+}
+
 func TestMeanSquare(t *testing.T) {
 	a := Vector{1, 2, 3, 4, 5}
-	if !close(a.MeanSquare(), 13, 0.0001) {
+	if !closeTo(a.MeanSquare(), 13, 0.0001) {
 		t.Error("Vector MeanSquare isn't correct.")
 	}
 }
@@ -87,3 +360,49 @@ func TestString(t *testing.T) {
 		t.Error("Vector String isn't correct.", a.String())
 	}
 }
+
+func TestStringLabeled(t *testing.T) {
+	a := Vector{.01, .001, 3}
+	if s := a.StringLabeled([]string{"lr", "reg", "depth"}); s != "{lr: 0.01, reg: 0.001, depth: 3}" {
+		t.Error("Vector StringLabeled isn't correct.", s)
+	}
+	if s := a.StringLabeled([]string{"lr"}); s != "{lr: 0.01, 1: 0.001, 2: 3}" {
+		t.Error("Vector StringLabeled didn't fall back to indices for missing labels.", s)
+	}
+	if s := a.Sub(0, 1).StringLabeled([]string{"lr", "reg"}); s != "{lr: 0.01}" {
+		t.Error("Vector StringLabeled didn't ignore extra labels.", s)
+	}
+}
+
+func TestCSV(t *testing.T) {
+	a := Vector{1, -2.5, 1e-10, 3.141592653589793}
+	b, err := ParseCSV(a.MarshalCSV())
+
+	if err != nil {
+		t.Error("ParseCSV failed on MarshalCSV output.", err)
+	} else if !reflect.DeepEqual(a, b) {
+		t.Error("Vector didn't round-trip through CSV.", a.MarshalCSV(), b.String())
+	}
+
+	if c, err := ParseCSV(" 1, 2 ,3"); err != nil || !reflect.DeepEqual(c, Vector{1, 2, 3}) {
+		t.Error("ParseCSV didn't ignore whitespace.", c, err)
+	}
+	for _, bad := range []string{"1,,2", "1,x", "1;2"} {
+		if _, err := ParseCSV(bad); err == nil {
+			t.Error("ParseCSV accepted malformed input.", bad)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	a := Vector{1, -2.5, 3}
+	data, err := json.Marshal(a)
+	if err != nil || string(data) != "[1,-2.5,3]" {
+		t.Error("Vector didn't marshal as a plain JSON array.", string(data), err)
+	}
+
+	var b Vector
+	if err := json.Unmarshal(data, &b); err != nil || !reflect.DeepEqual(a, b) {
+		t.Error("Vector didn't round-trip through JSON.", b, err)
+	}
+}